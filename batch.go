@@ -0,0 +1,278 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+// sweepMaxSteps borne le nombre de Next() consécutifs d'un balayage avant de sauter par Seek:
+// les lots denses avancent pas à pas, les lots épars ne parcourent pas tout le bucket.
+const sweepMaxSteps = 16
+
+// BatchResult est le résultat d'une adresse d'un lot, à la même position que dans l'entrée.
+// Addr est l'adresse normalisée (IPv4-mapped ramenée en IPv4), invalide si Err est ErrInvalidIP.
+// Err vaut nil, ErrInvalidIP ou ErrNotFound (errors.Is).
+type BatchResult struct {
+	Addr    netip.Addr
+	Country string
+	Err     error
+}
+
+// lookupBatch parse les chaînes puis résout le lot; les entrées invalides portent ErrInvalidIP.
+func (l *IPLocator) lookupBatch(ips []string) ([]BatchResult, error) {
+	addrs := make([]netip.Addr, len(ips))
+	for i, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs[i] = addr
+		}
+	}
+
+	results, err := l.lookupAddrs(addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		if results[i].Err == ErrInvalidIP {
+			results[i].Err = fmt.Errorf("%w: %q", ErrInvalidIP, ips[i])
+		}
+	}
+
+	return results, nil
+}
+
+// lookupAddrs résout un lot d'adresses sans passer par le cache.
+// Les adresses sont triées, puis résolues dans une seule transaction de lecture par un balayage
+// en avant de chaque bucket numérique (IPv4 puis IPv6); avec WithTextFallback, les adresses restées
+// sans plage sont confrontées en une passe au bucket texte. En mode mémoire, l'instantané est utilisé.
+// Les résultats sont rendus dans l'ordre d'entrée; l'erreur retournée ne concerne que le lot entier.
+func (l *IPLocator) lookupAddrs(addrs []netip.Addr) ([]BatchResult, error) {
+	results := make([]BatchResult, len(addrs))
+	keys := make([][]byte, len(addrs))
+
+	var v4, v6 []int
+	for i, addr := range addrs {
+		if !addr.IsValid() {
+			results[i].Err = ErrInvalidIP
+			l.metrics.invalid.Add(1)
+			continue
+		}
+
+		addr = addr.Unmap().WithZone("")
+		results[i].Addr = addr
+		keys[i] = addr.AsSlice()
+		if addr.Is4() {
+			v4 = append(v4, i)
+		} else {
+			v6 = append(v6, i)
+		}
+	}
+
+	// In memory mode, answer from the snapshot without touching BoltDB
+	if table := l.table.Load(); table != nil {
+		l.metrics.memory.Add(uint64(len(v4) + len(v6)))
+		for _, group := range [][]int{v4, v6} {
+			for _, i := range group {
+				if match, found := table.lookupAddr(results[i].Addr); found {
+					results[i].Country = match.country
+				} else {
+					l.setNotFound(&results[i])
+				}
+			}
+		}
+		return results, nil
+	}
+
+	byAddr := func(group []int) {
+		sort.Slice(group, func(a, b int) bool {
+			return results[group[a]].Addr.Less(results[group[b]].Addr)
+		})
+	}
+	byAddr(v4)
+	byAddr(v6)
+
+	var misses []int
+	err := l.DBManager.DB.View(func(tx *bbolt.Tx) error {
+		l.metrics.numeric.Add(uint64(len(v4) + len(v6)))
+
+		groups := []struct {
+			bucket  string
+			indexes []int
+		}{
+			{"ip_ranges_numeric", v4},
+			{"ip_ranges_numeric_v6", v6},
+		}
+		for _, g := range groups {
+			if len(g.indexes) == 0 {
+				continue
+			}
+
+			// Without a numeric bucket every address is a miss
+			bucket := tx.Bucket([]byte(g.bucket))
+			if bucket == nil {
+				if !l.textFallback {
+					return bucketMissing(g.bucket)
+				}
+				misses = append(misses, g.indexes...)
+				continue
+			}
+
+			sweep := newRangeSweep(bucket)
+			for _, i := range g.indexes {
+				if v := sweep.lookup(keys[i]); v != nil {
+					results[i].Country = string(v)
+				} else {
+					misses = append(misses, i)
+				}
+			}
+		}
+
+		if len(misses) == 0 || !l.textFallback {
+			return nil
+		}
+
+		textBucket := tx.Bucket([]byte("ip_ranges"))
+		if textBucket == nil {
+			return bucketMissing("ip_ranges")
+		}
+		l.metrics.text.Add(uint64(len(misses)))
+		matchTextRanges(textBucket, results, keys, misses)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range misses {
+		if results[i].Country == "" {
+			l.setNotFound(&results[i])
+		}
+	}
+
+	return results, nil
+}
+
+// setNotFound marque un résultat comme non trouvé.
+func (l *IPLocator) setNotFound(r *BatchResult) {
+	l.metrics.notFound.Add(1)
+	r.Err = fmt.Errorf("%w for IP: %s", ErrNotFound, r.Addr)
+}
+
+// matchTextRanges parcourt une fois le bucket texte et affecte chaque plage aux adresses de misses
+// (triées par adresse au sein de chaque famille, keys[i] forme binaire de l'adresse i) qu'elle
+// contient et qui n'ont pas encore de pays.
+// Comme pour un lookup unitaire, la première clé texte contenant l'adresse l'emporte.
+func matchTextRanges(bucket *bbolt.Bucket, results []BatchResult, keys [][]byte, misses []int) {
+	var v4, v6 []int
+	for _, i := range misses {
+		if results[i].Addr.Is4() {
+			v4 = append(v4, i)
+		} else {
+			v6 = append(v6, i)
+		}
+	}
+
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		key, err := parseRangeKey(string(k))
+		if err != nil {
+			continue
+		}
+
+		group := v4
+		if len(key) == 32 {
+			group = v6
+		}
+		width := len(key) / 2
+		start, end := key[:width], key[width:]
+
+		// First miss at or after start
+		j := sort.Search(len(group), func(j int) bool {
+			return bytes.Compare(keys[group[j]], start) >= 0
+		})
+		for ; j < len(group); j++ {
+			r := &results[group[j]]
+			if bytes.Compare(keys[group[j]], end) > 0 {
+				break
+			}
+			if r.Country == "" {
+				r.Country = string(v)
+			}
+		}
+	}
+}
+
+// rangeSweep résout des adresses croissantes sur un bucket numérique (clés start|end) avec un seul
+// curseur qui ne fait qu'avancer: cur est la dernière plage de start <= adresse courante,
+// next la suivante (nil en fin de bucket).
+type rangeSweep struct {
+	c       *bbolt.Cursor
+	started bool
+	cur     []byte
+	curV    []byte
+	next    []byte
+	nextV   []byte
+}
+
+// newRangeSweep prépare un balayage du bucket.
+func newRangeSweep(bucket *bbolt.Bucket) *rangeSweep {
+	return &rangeSweep{c: bucket.Cursor()}
+}
+
+// lookup retourne la valeur de la plage contenant addr, ou nil.
+// Les adresses doivent être fournies par ordre croissant et de même largeur que les bornes des clés.
+func (s *rangeSweep) lookup(addr []byte) []byte {
+	width := len(addr)
+
+	if !s.started {
+		s.started = true
+		s.seek(addr)
+	} else {
+		for steps := 0; s.next != nil && bytes.Compare(s.next[:width], addr) <= 0; steps++ {
+			// Too far behind: jump ahead instead of walking every range
+			if steps == sweepMaxSteps {
+				s.seek(addr)
+				break
+			}
+			s.cur, s.curV = s.next, s.nextV
+			s.next, s.nextV = s.c.Next()
+		}
+	}
+
+	if len(s.cur) >= 2*width && bytes.Compare(addr, s.cur[width:2*width]) <= 0 {
+		return s.curV
+	}
+	return nil
+}
+
+// seek positionne le balayage sur addr (même principe que seekRange).
+func (s *rangeSweep) seek(addr []byte) {
+	width := len(addr)
+	target := make([]byte, 2*width)
+	copy(target[0:width], addr)
+	for i := width; i < 2*width; i++ {
+		target[i] = 0xFF
+	}
+
+	k, v := s.c.Seek(target)
+	if k != nil && bytes.Equal(k, target) {
+		s.cur, s.curV = k, v
+		s.next, s.nextV = s.c.Next()
+		return
+	}
+
+	if k == nil {
+		s.cur, s.curV = s.c.Last()
+		s.next, s.nextV = nil, nil
+		return
+	}
+
+	// Read the predecessor, then move the cursor back onto the successor
+	s.cur, s.curV = s.c.Prev()
+	s.next, s.nextV = s.c.Seek(target)
+}
@@ -0,0 +1,194 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// populateBatchRanges écrit 4096 plages /24 (une par /20, pays alternés) et quelques plages IPv6.
+func populateBatchRanges(t testing.TB, manager *DBManager) {
+	batch := make([]zoneEntry, 0)
+	countries := []string{"FR", "DE", "US"}
+
+	for i := uint32(0); i < 1<<12; i++ {
+		start := i << 20
+		end := start | 0xFF
+		batch = append(batch, zoneEntry{
+			Text:    fmt.Sprintf("%d.%d.0.0/24", start>>24, (start>>16)&0xFF),
+			Country: countries[i%3],
+			Key:     ipv4RangeKey(start, end),
+		})
+	}
+
+	for _, r := range []struct{ text, country string }{
+		{"2001:db8::/32", "FR"},
+		{"2a00::/16", "DE"},
+	} {
+		key, _ := parseRangeKey(r.text)
+		batch = append(batch, zoneEntry{Text: r.text, Country: r.country, Key: key})
+	}
+
+	if err := manager.writeBatch(batch, newImportState(ConflictLastFileWins), &FileReport{}); err != nil {
+		t.Fatalf("Error populating database: %v", err)
+	}
+}
+
+func TestLookupBatch(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	populateBatchRanges(t, manager)
+
+	// A range only present in the text bucket is resolved by the fallback
+	err := manager.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("ip_ranges")).Put([]byte("0.1.0.0-0.1.0.255"), []byte("CH"))
+	})
+	if err != nil {
+		t.Fatalf("Error adding text range: %v", err)
+	}
+
+	ips := []string{
+		"255.240.0.1", "0.0.0.1", "bogus", "0.1.0.7", "0.1.0.7", "2001:db8::1",
+		"::ffff:0.16.0.5", "2a00:1::1", "3000::1", "0.0.1.0", "", "0.16.0.255",
+	}
+
+	// Dense and sparse random addresses exercise both cursor steps and jumps
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		n := rng.Uint32()
+		if i%2 == 0 {
+			// Inside one of the first 256 ranges
+			n &= 0x0FF000FF
+		}
+		ips = append(ips, netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}).String())
+	}
+
+	locator := newIPLocator(manager, 0, WithTextFallback())
+	results, err := locator.lookupBatch(ips)
+	if err != nil {
+		t.Fatalf("Error during batch lookup: %v", err)
+	}
+	if len(results) != len(ips) {
+		t.Fatalf("Incorrect result count. Expected: %d, Got: %d", len(ips), len(results))
+	}
+
+	found := 0
+	for i, ip := range ips {
+		country, err := locator.lookupCountryByIP(ip)
+		r := results[i]
+
+		if r.Country != country {
+			t.Errorf("%s: batch returned %q, single lookup %q", ip, r.Country, country)
+		}
+		switch {
+		case errors.Is(err, ErrInvalidIP):
+			if !errors.Is(r.Err, ErrInvalidIP) {
+				t.Errorf("%s: expected ErrInvalidIP, got: %v", ip, r.Err)
+			}
+		case errors.Is(err, ErrNotFound):
+			if !errors.Is(r.Err, ErrNotFound) {
+				t.Errorf("%s: expected ErrNotFound, got: %v", ip, r.Err)
+			}
+		case r.Err != nil:
+			t.Errorf("%s: unexpected error: %v", ip, r.Err)
+		default:
+			found++
+		}
+	}
+	if found < 100 {
+		t.Errorf("Too few addresses resolved: %d", found)
+	}
+
+	if results[3].Country != "CH" || results[4].Country != "CH" {
+		t.Errorf("The text fallback was not applied: %+v", results[3])
+	}
+	if results[6].Addr != netip.MustParseAddr("0.16.0.5") || results[6].Country != "DE" {
+		t.Errorf("The IPv4-mapped address was not normalized: %+v", results[6])
+	}
+	if results[5].Country != "FR" || results[7].Country != "DE" {
+		t.Errorf("Incorrect IPv6 results: %+v, %+v", results[5], results[7])
+	}
+}
+
+func TestLookupAddrs(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	populateBatchRanges(t, manager)
+
+	addrs := []netip.Addr{
+		netip.MustParseAddr("0.32.0.1"),
+		{},
+		netip.MustParseAddr("2001:db8::ffff"),
+		netip.MustParseAddr("0.32.1.1"),
+	}
+	expected := []string{"US", "", "FR", ""}
+
+	locator := newIPLocator(manager, 100, WithTextFallback())
+	memoryLocator, err := newMemoryIPLocator(manager, 100)
+	if err != nil {
+		t.Fatalf("Failed to create memory locator: %v", err)
+	}
+
+	for name, l := range map[string]*IPLocator{"bolt": locator, "memory": memoryLocator} {
+		results, err := l.lookupAddrs(addrs)
+		if err != nil {
+			t.Fatalf("%s: error during batch lookup: %v", name, err)
+		}
+		for i, r := range results {
+			if r.Country != expected[i] {
+				t.Errorf("%s: incorrect country for %v. Expected: %q, Got: %q", name, addrs[i], expected[i], r.Country)
+			}
+		}
+		if !errors.Is(results[1].Err, ErrInvalidIP) || !errors.Is(results[3].Err, ErrNotFound) {
+			t.Errorf("%s: incorrect per-item errors: %v, %v", name, results[1].Err, results[3].Err)
+		}
+
+		// The cache is bypassed
+		if l.Cache.len() != 0 {
+			t.Errorf("%s: the batch populated the cache", name)
+		}
+	}
+
+	if st := locator.stats(); st.NumericLookups != 3 || st.TextLookups != 1 || st.NotFound != 1 || st.InvalidIP != 1 {
+		t.Errorf("Incorrect batch counters: %+v", st)
+	}
+}
+
+// BenchmarkLookupBatch compare un lot de 10 000 adresses à autant de Lookup unitaires (hors cache).
+func BenchmarkLookupBatch(b *testing.B) {
+	manager, _, cleanup := setupTestDB(b)
+	defer cleanup()
+
+	populateBatchRanges(b, manager)
+	locator := newIPLocator(manager, 0)
+
+	// Every address hits a range: misses would measure the text fallback instead
+	rng := rand.New(rand.NewSource(1))
+	ips := make([]string, 10000)
+	for i := range ips {
+		n := rng.Uint32() & 0xFFF000FF
+		ips[i] = netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), 0, byte(n)}).String()
+	}
+
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := locator.lookupBatch(ips); err != nil {
+				b.Fatalf("Error during batch lookup: %v", err)
+			}
+		}
+	})
+
+	b.Run("single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, ip := range ips {
+				locator.lookupCountryByIP(ip)
+			}
+		}
+	})
+}
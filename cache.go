@@ -0,0 +1,329 @@
+package ipcountrylocator
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxCacheShards borne le nombre de segments du cache (puissance de 2).
+	maxCacheShards = 16
+	// minShardSize évite de segmenter les petits caches: chaque segment garde au moins autant d'entrées.
+	minShardSize = 64
+	// noEntry marque l'absence de voisin dans la liste LRU.
+	noEntry = -1
+)
+
+// IPCache fournit un cache (clé: adresse normalisée -> code pays) à taille bornée avec éviction LRU.
+// Les entrées sont réparties sur plusieurs segments (hash FNV-1a de l'adresse), chacun protégé par son
+// propre verrou, pour limiter la contention entre lookups concurrents.
+// Si ttl > 0, une entrée plus ancienne que ttl est considérée absente (ex: après un rafraîchissement).
+// gen est incrémenté par reset: un résultat lu avant un reset n'est plus inséré (voir putAt).
+type IPCache struct {
+	shards  []cacheShard
+	mask    uint32
+	maxSize int
+	ttl     time.Duration
+	gen     atomic.Uint64
+}
+
+// cacheShard est un segment LRU: les entrées sont stockées dans un slice pré-alloué et chaînées
+// par indices (head = plus récente, tail = plus ancienne), index associe une clé à sa position.
+// Les compteurs sont protégés par le verrou du segment (pas de contention entre segments).
+type cacheShard struct {
+	mutex    sync.Mutex
+	index    map[netip.Addr]int32
+	entries  []cacheEntry
+	capacity int
+	head     int32
+	tail     int32
+
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	expirations uint64
+}
+
+// cacheStats agrège les compteurs de tous les segments.
+type cacheStats struct {
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	expirations uint64
+	size        int
+}
+
+// cacheEntry est une entrée de la liste LRU: pays et plage retenus (expires en nanosecondes Unix,
+// 0 = sans expiration).
+type cacheEntry struct {
+	key     netip.Addr
+	match   rangeMatch
+	expires int64
+	prev    int32
+	next    int32
+}
+
+// newIPCache instancie un cache de maxSize entrées au total (maxSize <= 0: cache désactivé).
+// ttl <= 0 désactive l'expiration.
+func newIPCache(maxSize int, ttl time.Duration) *IPCache {
+	c := &IPCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+	}
+	if maxSize <= 0 {
+		return c
+	}
+
+	shardCount := 1
+	for shardCount < maxCacheShards && maxSize/(shardCount*2) >= minShardSize {
+		shardCount *= 2
+	}
+
+	c.shards = make([]cacheShard, shardCount)
+	c.mask = uint32(shardCount - 1)
+
+	// Spread the capacity so that the shards sum up to maxSize
+	for i := range c.shards {
+		capacity := maxSize / shardCount
+		if i < maxSize%shardCount {
+			capacity++
+		}
+		c.shards[i].init(capacity)
+	}
+
+	return c
+}
+
+// shard sélectionne le segment d'une adresse (FNV-1a 32 bits, sans allocation).
+func (c *IPCache) shard(key netip.Addr) *cacheShard {
+	b := key.As16()
+	hash := uint32(2166136261)
+	for i := 0; i < len(b); i++ {
+		hash ^= uint32(b[i])
+		hash *= 16777619
+	}
+	return &c.shards[hash&c.mask]
+}
+
+// getCountry récupère le pays d'une entrée du cache (voir get).
+func (c *IPCache) getCountry(ip netip.Addr) (string, bool) {
+	match, found := c.get(ip)
+	return match.country, found
+}
+
+// putCountry insère ou met à jour le pays d'une entrée, sans plage (voir put).
+func (c *IPCache) putCountry(ip netip.Addr, country string) {
+	c.put(ip, rangeMatch{country: country})
+}
+
+// get récupère une entrée du cache et la marque comme la plus récente.
+// Une entrée expirée est supprimée et signalée absente.
+// Thread-safe (verrou du segment).
+func (c *IPCache) get(ip netip.Addr) (rangeMatch, bool) {
+	if c.maxSize <= 0 {
+		return rangeMatch{}, false
+	}
+
+	s := c.shard(ip)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i, found := s.index[ip]
+	if !found {
+		s.misses++
+		return rangeMatch{}, false
+	}
+
+	e := &s.entries[i]
+	if e.expires != 0 && time.Now().UnixNano() > e.expires {
+		s.remove(i)
+		s.expirations++
+		s.misses++
+		return rangeMatch{}, false
+	}
+
+	s.moveToFront(i)
+	s.hits++
+	return e.match, true
+}
+
+// generation retourne la génération courante du cache, à lire avant de consulter les données
+// dont le résultat sera inséré par putAt.
+func (c *IPCache) generation() uint64 {
+	return c.gen.Load()
+}
+
+// put insère ou met à jour une entrée (voir putAt), quelle que soit la génération.
+func (c *IPCache) put(ip netip.Addr, match rangeMatch) {
+	c.putAt(ip, match, c.generation())
+}
+
+// putAt insère ou met à jour une entrée (sans effet si maxSize <= 0: cache désactivé), sauf si le cache a été
+// vidé depuis la génération gen: le résultat a été lu avant une modification des données.
+// Quand le segment est plein, l'entrée la moins récemment utilisée est évincée.
+// Thread-safe (verrou du segment).
+func (c *IPCache) putAt(ip netip.Addr, match rangeMatch, gen uint64) {
+	if c.maxSize <= 0 {
+		return
+	}
+
+	var expires int64
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl).UnixNano()
+	}
+
+	s := c.shard(ip)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// reset bumps the generation before taking the shard locks: a stale result is either
+	// dropped here or cleared by reset
+	if c.gen.Load() != gen {
+		return
+	}
+
+	// Update in place
+	if i, found := s.index[ip]; found {
+		s.entries[i].match = match
+		s.entries[i].expires = expires
+		s.moveToFront(i)
+		return
+	}
+
+	// Evict the least recently used entry and reuse its slot
+	if len(s.entries) >= s.capacity {
+		i := s.tail
+		delete(s.index, s.entries[i].key)
+		s.evictions++
+		s.entries[i].key = ip
+		s.entries[i].match = match
+		s.entries[i].expires = expires
+		s.index[ip] = i
+		s.moveToFront(i)
+		return
+	}
+
+	i := int32(len(s.entries))
+	s.entries = append(s.entries, cacheEntry{key: ip, match: match, expires: expires, prev: noEntry, next: noEntry})
+	s.index[ip] = i
+	s.pushFront(i)
+}
+
+// len retourne le nombre d'entrées présentes (expirées comprises tant qu'elles n'ont pas été lues).
+func (c *IPCache) len() int {
+	n := 0
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mutex.Lock()
+		n += len(s.entries)
+		s.mutex.Unlock()
+	}
+	return n
+}
+
+// stats retourne les compteurs cumulés et le nombre d'entrées présentes.
+func (c *IPCache) stats() cacheStats {
+	var st cacheStats
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mutex.Lock()
+		st.hits += s.hits
+		st.misses += s.misses
+		st.evictions += s.evictions
+		st.expirations += s.expirations
+		st.size += len(s.entries)
+		s.mutex.Unlock()
+	}
+	return st
+}
+
+// reset vide le cache (les compteurs sont conservés) et passe à la génération suivante.
+// Thread-safe (verrou de chaque segment).
+func (c *IPCache) reset() {
+	c.gen.Add(1)
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mutex.Lock()
+		clear(s.index)
+		clear(s.entries)
+		s.entries = s.entries[:0]
+		s.head, s.tail = noEntry, noEntry
+		s.mutex.Unlock()
+	}
+}
+
+// init pré-alloue un segment de capacity entrées.
+func (s *cacheShard) init(capacity int) {
+	s.index = make(map[netip.Addr]int32, capacity)
+	s.entries = make([]cacheEntry, 0, capacity)
+	s.capacity = capacity
+	s.head, s.tail = noEntry, noEntry
+}
+
+// pushFront place l'entrée i (détachée) en tête de liste.
+func (s *cacheShard) pushFront(i int32) {
+	e := &s.entries[i]
+	e.prev = noEntry
+	e.next = s.head
+	if s.head != noEntry {
+		s.entries[s.head].prev = i
+	}
+	s.head = i
+	if s.tail == noEntry {
+		s.tail = i
+	}
+}
+
+// unlink détache l'entrée i de la liste.
+func (s *cacheShard) unlink(i int32) {
+	e := &s.entries[i]
+	if e.prev != noEntry {
+		s.entries[e.prev].next = e.next
+	} else {
+		s.head = e.next
+	}
+	if e.next != noEntry {
+		s.entries[e.next].prev = e.prev
+	} else {
+		s.tail = e.prev
+	}
+	e.prev, e.next = noEntry, noEntry
+}
+
+// moveToFront marque l'entrée i comme la plus récente.
+func (s *cacheShard) moveToFront(i int32) {
+	if s.head == i {
+		return
+	}
+	s.unlink(i)
+	s.pushFront(i)
+}
+
+// remove supprime l'entrée i; la dernière entrée du slice est déplacée dans l'emplacement libéré
+// pour garder le stockage contigu.
+func (s *cacheShard) remove(i int32) {
+	s.unlink(i)
+	delete(s.index, s.entries[i].key)
+
+	last := int32(len(s.entries) - 1)
+	if i != last {
+		moved := s.entries[last]
+		s.entries[i] = moved
+		s.index[moved.key] = i
+		if moved.prev != noEntry {
+			s.entries[moved.prev].next = i
+		} else {
+			s.head = i
+		}
+		if moved.next != noEntry {
+			s.entries[moved.next].prev = i
+		} else {
+			s.tail = i
+		}
+	}
+
+	s.entries[last] = cacheEntry{}
+	s.entries = s.entries[:last]
+}
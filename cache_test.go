@@ -0,0 +1,175 @@
+package ipcountrylocator
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIPCache(t *testing.T) {
+	// Create a cache with a maximum size of 3 entries
+	cache := newIPCache(3, 0)
+
+	// Test adding elements
+	cache.putCountry(netip.MustParseAddr("192.168.1.1"), "FR")
+	cache.putCountry(netip.MustParseAddr("10.0.0.1"), "DE")
+	country, found := cache.getCountry(netip.MustParseAddr("192.168.1.1"))
+
+	if !found {
+		t.Error("The entry should exist in the cache")
+	}
+
+	if country != "FR" {
+		t.Errorf("Incorrect country for IP. Expected: FR, Got: %s", country)
+	}
+
+	// Test a non-existent element
+	_, found = cache.getCountry(netip.MustParseAddr("8.8.8.8"))
+	if found {
+		t.Error("The entry should not exist in the cache")
+	}
+
+	// Test cache overflow: only the least recently used entry is evicted
+	cache.putCountry(netip.MustParseAddr("172.16.0.1"), "US")
+	cache.putCountry(netip.MustParseAddr("8.8.8.8"), "US")
+
+	if _, found = cache.getCountry(netip.MustParseAddr("10.0.0.1")); found {
+		t.Error("The least recently used entry should have been evicted")
+	}
+
+	if country, found = cache.getCountry(netip.MustParseAddr("192.168.1.1")); !found || country != "FR" {
+		t.Error("A recently used entry was evicted")
+	}
+
+	country, found = cache.getCountry(netip.MustParseAddr("8.8.8.8"))
+	if !found || country != "US" {
+		t.Error("The new entry was not correctly added after eviction")
+	}
+
+	if n := cache.len(); n != 3 {
+		t.Errorf("Incorrect cache length. Expected: 3, Got: %d", n)
+	}
+
+	// Updating an entry does not grow the cache
+	cache.putCountry(netip.MustParseAddr("8.8.8.8"), "CA")
+	if country, _ = cache.getCountry(netip.MustParseAddr("8.8.8.8")); country != "CA" || cache.len() != 3 {
+		t.Errorf("Incorrect update: %s, %d entries", country, cache.len())
+	}
+
+	cache.reset()
+	if _, found = cache.getCountry(netip.MustParseAddr("8.8.8.8")); found || cache.len() != 0 {
+		t.Error("The cache was not emptied by reset")
+	}
+}
+
+func TestIPCacheDisabled(t *testing.T) {
+	cache := newIPCache(0, 0)
+	cache.putCountry(netip.MustParseAddr("8.8.8.8"), "US")
+	if _, found := cache.getCountry(netip.MustParseAddr("8.8.8.8")); found {
+		t.Error("A disabled cache should not store entries")
+	}
+	cache.reset()
+}
+
+func TestIPCacheTTL(t *testing.T) {
+	cache := newIPCache(10, 20*time.Millisecond)
+
+	cache.putCountry(netip.MustParseAddr("8.8.8.8"), "US")
+	cache.putCountry(netip.MustParseAddr("1.1.1.1"), "AU")
+	if _, found := cache.getCountry(netip.MustParseAddr("8.8.8.8")); !found {
+		t.Fatal("The entry should exist before expiration")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, found := cache.getCountry(netip.MustParseAddr("8.8.8.8")); found {
+		t.Error("The entry should have expired")
+	}
+	if n := cache.len(); n != 1 {
+		t.Errorf("The expired entry was not removed. Got %d entries", n)
+	}
+
+	// The remaining entry was moved into the freed slot and is still reachable
+	cache.putCountry(netip.MustParseAddr("9.9.9.9"), "CH")
+	if country, found := cache.getCountry(netip.MustParseAddr("9.9.9.9")); !found || country != "CH" {
+		t.Error("A fresh entry should be found")
+	}
+}
+
+func TestIPCacheGeneration(t *testing.T) {
+	cache := newIPCache(10, 0)
+	ip := netip.MustParseAddr("1.0.0.1")
+
+	// A result read before a reset is not cached afterwards
+	gen := cache.generation()
+	cache.reset()
+	cache.putAt(ip, rangeMatch{country: "FR"}, gen)
+	if _, found := cache.get(ip); found {
+		t.Error("A result read before the reset should not be cached")
+	}
+
+	// A result read after the reset is
+	cache.putAt(ip, rangeMatch{country: "DE"}, cache.generation())
+	if country, found := cache.getCountry(ip); !found || country != "DE" {
+		t.Errorf("Incorrect cached country. Expected: DE, Got: %q (found: %v)", country, found)
+	}
+}
+
+func TestIPCacheSharding(t *testing.T) {
+	cache := newIPCache(10000, 0)
+	if len(cache.shards) != maxCacheShards {
+		t.Fatalf("Incorrect shard count. Expected: %d, Got: %d", maxCacheShards, len(cache.shards))
+	}
+
+	capacity := 0
+	for i := range cache.shards {
+		capacity += cache.shards[i].capacity
+	}
+	if capacity != 10000 {
+		t.Errorf("Incorrect total capacity. Expected: 10000, Got: %d", capacity)
+	}
+
+	// Small caches are not split
+	if n := len(newIPCache(100, 0).shards); n != 1 {
+		t.Errorf("A small cache should use a single shard, got %d", n)
+	}
+
+	// The cache never exceeds its bound
+	for i := 0; i < 50000; i++ {
+		cache.putCountry(netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}), "FR")
+	}
+	if n := cache.len(); n > 10000 {
+		t.Errorf("The cache exceeded its size: %d", n)
+	}
+	if _, found := cache.getCountry(netip.MustParseAddr("10.0.195.79")); !found {
+		t.Error("The most recent entry should still be cached")
+	}
+}
+
+func TestIPCacheConcurrent(t *testing.T) {
+	cache := newIPCache(512, time.Minute)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				ip := netip.AddrFrom4([4]byte{10, byte(w), byte(i >> 8 & 3), byte(i)})
+				cache.putCountry(ip, "FR")
+				if country, found := cache.getCountry(ip); found && country != "FR" {
+					t.Errorf("Incorrect country: %s", country)
+				}
+				if i%500 == 0 {
+					cache.reset()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n := cache.len(); n > 512 {
+		t.Errorf("The cache exceeded its size: %d", n)
+	}
+}
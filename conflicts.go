@@ -0,0 +1,195 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ConflictPolicy détermine le traitement d'une plage importée qui chevauche une plage d'un autre pays.
+type ConflictPolicy int
+
+const (
+	// ConflictLastFileWins conserve la plage importée en dernier; les plages qu'elle chevauche sont réduites
+	// à leur partie hors de celle-ci (défaut).
+	ConflictLastFileWins ConflictPolicy = iota
+	// ConflictReject ignore la plage importée et conserve les plages existantes.
+	ConflictReject
+	// ConflictMostSpecificWins départage chaque chevauchement en faveur de la plage strictement la plus petite:
+	// une plage plus large est réduite à sa partie hors de la plus spécifique, qu'elle soit déjà en base
+	// ou importée (le résultat ne dépend pas de l'ordre des fichiers). À taille égale, la plage existante est conservée.
+	ConflictMostSpecificWins
+)
+
+// String retourne le nom de la politique.
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictReject:
+		return "reject"
+	case ConflictMostSpecificWins:
+		return "most-specific-wins"
+	default:
+		return "last-file-wins"
+	}
+}
+
+// RangeSource identifie l'origine d'une plage: texte, pays, fichier et numéro de ligne.
+// File est vide (et Line nul) pour une plage déjà présente en base avant l'import;
+// Range est alors reconstruit sous la forme "start-end".
+type RangeSource struct {
+	Range   string
+	Country string
+	File    string
+	Line    int
+}
+
+// RangeConflict décrit une collision entre une plage déjà stockée et une plage importée d'un autre pays.
+// KeptIncoming indique laquelle des deux l'a emporté sur leur partie commune selon la politique appliquée;
+// la perdante conserve ses adresses hors de la gagnante.
+type RangeConflict struct {
+	Existing     RangeSource
+	Incoming     RangeSource
+	KeptIncoming bool
+}
+
+// importState conserve l'origine des plages écrites pendant un import (éventuellement multi-fichiers),
+// les conflits rencontrés, les lignes invalides et les plages réécrites sous forme canonique.
+// buckets désigne les buckets cibles (live ou staging); strict interrompt l'import à la première
+// erreur d'écriture au lieu de poursuivre. runID et importedAt identifient l'import dans la provenance
+// des plages écrites.
+type importState struct {
+	policy     ConflictPolicy
+	runID      string
+	importedAt time.Time
+	buckets    bucketSet
+	strict     bool
+	sources    map[string]RangeSource
+	conflicts  []RangeConflict
+	errors     []LineError
+	rewrites   []RangeRewrite
+}
+
+// newImportState prépare l'état d'un import vers les buckets live pour une politique donnée.
+func newImportState(policy ConflictPolicy) *importState {
+	return &importState{
+		policy:     policy,
+		runID:      newRunID(),
+		importedAt: time.Now(),
+		buckets:    liveBuckets,
+		sources:    make(map[string]RangeSource),
+	}
+}
+
+// rangeOverlap représente une plage du bucket numérique chevauchant une plage importée.
+type rangeOverlap struct {
+	key     []byte
+	country string
+}
+
+// findOverlaps retourne les plages d'un autre pays que country qui chevauchent key (start|end).
+func findOverlaps(bucket *bbolt.Bucket, key []byte, country string) []rangeOverlap {
+	var overlaps []rangeOverlap
+	for _, o := range overlappingRanges(bucket, key) {
+		if o.country != country {
+			overlaps = append(overlaps, o)
+		}
+	}
+	return overlaps
+}
+
+// overlappingRanges retourne les plages, tous pays confondus, qui chevauchent key (start|end).
+// Seuls le prédécesseur immédiat et les plages commençant dans [start, end] sont examinés:
+// le bucket est supposé sans chevauchement, invariant maintenu par les écritures (fusion des plages
+// d'un même pays, découpage selon la politique de conflit entre pays).
+func overlappingRanges(bucket *bbolt.Bucket, key []byte) []rangeOverlap {
+	width := len(key) / 2
+	start, end := key[:width], key[width:]
+
+	// Smallest possible key starting at start
+	seek := make([]byte, len(key))
+	copy(seek[:width], start)
+
+	var overlaps []rangeOverlap
+	add := func(k, v []byte) {
+		overlaps = append(overlaps, rangeOverlap{
+			key:     append([]byte(nil), k...),
+			country: string(v),
+		})
+	}
+
+	c := bucket.Cursor()
+
+	// The predecessor starts before start but may extend into the range
+	k, v := c.Seek(seek)
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if len(k) == len(key) && bytes.Compare(k[width:], start) >= 0 {
+		add(k, v)
+	}
+
+	// Every range starting inside [start, end] overlaps
+	for k, v = c.Seek(seek); k != nil; k, v = c.Next() {
+		if len(k) != len(key) {
+			continue
+		}
+		if bytes.Compare(k[:width], end) > 0 {
+			break
+		}
+		add(k, v)
+	}
+
+	return overlaps
+}
+
+// rangeSpan calcule end - start (big-endian) pour une clé start|end.
+func rangeSpan(key []byte) []byte {
+	width := len(key) / 2
+	span := make([]byte, width)
+	borrow := 0
+	for i := width - 1; i >= 0; i-- {
+		d := int(key[width+i]) - int(key[i]) - borrow
+		if d < 0 {
+			d += 256
+			borrow = 1
+		} else {
+			borrow = 0
+		}
+		span[i] = byte(d)
+	}
+	return span
+}
+
+// resolve applique la politique à une plage importée key et aux plages d'autres pays qu'elle chevauche.
+// Retourne, pour chaque plage chevauchée, si la plage importée l'emporte (la plage chevauchée est alors
+// réduite à sa partie hors de key, jamais supprimée au-delà), et les parties de key à écrire: key privée
+// des plages qui l'emportent, aucune avec ConflictReject dès qu'il y a un chevauchement.
+func (p ConflictPolicy) resolve(key []byte, overlaps []rangeOverlap) ([]bool, [][]byte) {
+	wins := make([]bool, len(overlaps))
+	parts := [][]byte{key}
+
+	for i, o := range overlaps {
+		switch p {
+		case ConflictReject:
+			return wins, nil
+		case ConflictMostSpecificWins:
+			wins[i] = bytes.Compare(rangeSpan(key), rangeSpan(o.key)) < 0
+		default:
+			wins[i] = true
+		}
+		if wins[i] {
+			continue
+		}
+
+		var rest [][]byte
+		for _, part := range parts {
+			rest = append(rest, subtractRange(part, o.key)...)
+		}
+		parts = rest
+	}
+
+	return wins, parts
+}
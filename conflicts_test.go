@@ -0,0 +1,265 @@
+package ipcountrylocator
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestRangeSpan(t *testing.T) {
+	testCases := []struct {
+		ipRange  string
+		expected string
+	}{
+		{"1.0.0.0/24", "0.0.0.255"},
+		{"1.0.0.5-1.0.1.4", "0.0.0.255"},
+		{"8.8.8.8-8.8.8.8", "0.0.0.0"},
+		{"0.0.0.0-255.255.255.255", "255.255.255.255"},
+	}
+
+	for _, tc := range testCases {
+		key, err := parseRangeKey(tc.ipRange)
+		if err != nil {
+			t.Fatalf("Error parsing %s: %v", tc.ipRange, err)
+		}
+		if span := net.IP(rangeSpan(key)).String(); span != tc.expected {
+			t.Errorf("For %s: expected span %s, got %s", tc.ipRange, tc.expected, span)
+		}
+	}
+
+	// IPv6 borrow across bytes
+	key, _ := parseRangeKey("2001:db8::ff-2001:db8::1:0")
+	if span := net.IP(rangeSpan(key)).String(); span != "::ff01" {
+		t.Errorf("Incorrect IPv6 span. Expected: ::ff01, Got: %s", span)
+	}
+}
+
+func TestFindOverlaps(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, r := range []struct {
+		ipRange string
+		country string
+	}{
+		{"1.0.0.0-1.0.0.255", "FR"},
+		{"1.0.2.0-1.0.2.255", "DE"},
+		{"1.0.4.0-1.0.4.255", "IT"},
+	} {
+		start, end, _ := parseIPRange(r.ipRange)
+		if _, err := manager.upsertIPRangeCountry(r.ipRange, start, end, r.country); err != nil {
+			t.Fatalf("Error adding IP range: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		ipRange  string
+		country  string
+		expected []string
+	}{
+		{"1.0.0.128-1.0.1.255", "US", []string{"FR"}},         // Predecessor overlap
+		{"1.0.1.0-1.0.3.0", "US", []string{"DE"}},             // Range starting inside
+		{"0.0.0.0-1.0.4.0", "US", []string{"FR", "DE", "IT"}}, // Spanning everything
+		{"1.0.0.128-1.0.1.255", "FR", nil},                    // Same country is not a conflict
+		{"1.0.1.0-1.0.1.255", "US", nil},                      // Gap
+		{"1.0.5.0-1.0.5.255", "US", nil},                      // After the last range
+		{"1.0.4.255-1.0.4.255", "US", []string{"IT"}},         // Last address of the last range
+		{"1.0.2.0-1.0.2.255", "US", []string{"DE"}},           // Identical range
+		{"2001:db8::/32", "US", nil},                          // Other family
+	}
+
+	err := manager.DB.View(func(tx *bbolt.Tx) error {
+		for _, tc := range testCases {
+			key, _ := parseRangeKey(tc.ipRange)
+			overlaps := findOverlaps(tx.Bucket([]byte(liveBuckets.numericFor(key))), key, tc.country)
+
+			if len(overlaps) != len(tc.expected) {
+				t.Errorf("For %s: expected %d overlaps, got %d", tc.ipRange, len(tc.expected), len(overlaps))
+				continue
+			}
+			for i, o := range overlaps {
+				if o.country != tc.expected[i] {
+					t.Errorf("For %s: expected overlap with %s, got %s", tc.ipRange, tc.expected[i], o.country)
+				}
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Error during overlap search: %v", err)
+	}
+}
+
+func TestImportConflictPolicies(t *testing.T) {
+	testCases := []struct {
+		policy      ConflictPolicy
+		keptFR      bool
+		rejected    int
+		expected105 string // Country expected for 1.0.5.1
+		expected101 string // Country expected for 1.0.1.1
+		rangesDE    int
+		rangesFR    int
+	}{
+		{ConflictLastFileWins, true, 0, "FR", "FR", 1, 2},
+		{ConflictReject, false, 1, "DE", "", 2, 1},
+		{ConflictMostSpecificWins, false, 0, "DE", "FR", 2, 3}, // FR /16 split around the DE /24
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			manager, tempDir, cleanup := setupTestDB(t)
+			defer cleanup()
+
+			manager.ConflictPolicy = tc.policy
+
+			// DE.zone is imported before FR.zone (alphabetical order)
+			if _, err := createTestZoneFile(tempDir, "DE", []string{"# DE ranges", "1.0.5.0/24", "3.0.0.0/24"}); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+			if _, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/16", "2.0.0.0/24"}); err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+
+			report, err := manager.importZoneDirectory(tempDir)
+			if err != nil {
+				t.Fatalf("Error processing directory: %v", err)
+			}
+
+			conflicts := report.Conflicts
+			if rejected := report.Totals().Rejected; rejected != tc.rejected {
+				t.Errorf("Incorrect number of rejected ranges: %d", rejected)
+			}
+
+			if len(conflicts) != 1 {
+				t.Fatalf("Incorrect number of conflicts. Expected: 1, Got: %d", len(conflicts))
+			}
+
+			c := conflicts[0]
+			if c.Existing.Range != "1.0.5.0/24" || c.Existing.Country != "DE" ||
+				c.Existing.File != filepath.Join(tempDir, "DE.zone") || c.Existing.Line != 2 {
+				t.Errorf("Incorrect existing side: %+v", c.Existing)
+			}
+			if c.Incoming.Range != "1.0.0.0/16" || c.Incoming.Country != "FR" ||
+				c.Incoming.File != filepath.Join(tempDir, "FR.zone") || c.Incoming.Line != 1 {
+				t.Errorf("Incorrect incoming side: %+v", c.Incoming)
+			}
+			if c.KeptIncoming != tc.keptFR {
+				t.Errorf("Incorrect resolution. Expected KeptIncoming: %v, Got: %v", tc.keptFR, c.KeptIncoming)
+			}
+
+			locator := newIPLocator(manager, 100)
+			for ip, expected := range map[string]string{"1.0.5.1": tc.expected105, "1.0.1.1": tc.expected101} {
+				country, _ := locator.lookupCountryByIP(ip)
+				if country != expected {
+					t.Errorf("Incorrect country for %s. Expected: %q, Got: %q", ip, expected, country)
+				}
+			}
+
+			// The text bucket keeps only what the policy left of each side
+			ranges, _ := locator.listIPRangesByCountry("DE")
+			if len(ranges) != tc.rangesDE {
+				t.Errorf("Incorrect DE ranges after conflict: %v", ranges)
+			}
+			ranges, _ = locator.listIPRangesByCountry("FR")
+			if len(ranges) != tc.rangesFR {
+				t.Errorf("Incorrect FR ranges after conflict: %v", ranges)
+			}
+		})
+	}
+}
+
+func TestImportConflictWithStoredRange(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.ConflictPolicy = ConflictMostSpecificWins
+
+	// Range stored before the import, without provenance
+	start, end, _ := parseIPRange("1.0.0.0/16")
+	if _, err := manager.upsertIPRangeCountry("1.0.0.0/16", start, end, "FR"); err != nil {
+		t.Fatalf("Error adding IP range: %v", err)
+	}
+
+	filePath, err := createTestZoneFile(tempDir, "DE", []string{"1.0.5.0/24"})
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	report, err := manager.importZoneFile(filePath)
+	if err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+
+	conflicts := report.Conflicts
+
+	if len(conflicts) != 1 {
+		t.Fatalf("Incorrect number of conflicts. Expected: 1, Got: %d", len(conflicts))
+	}
+
+	c := conflicts[0]
+	if c.Existing.Range != "1.0.0.0-1.0.255.255" || c.Existing.File != "" || c.Existing.Line != 0 {
+		t.Errorf("Incorrect existing side: %+v", c.Existing)
+	}
+	if !c.KeptIncoming {
+		t.Error("The more specific incoming range should have been kept")
+	}
+
+	// The enclosing FR range is split around the DE one, not dropped
+	locator := newIPLocator(manager, 100)
+	ranges, _ := locator.listIPRangesByCountry("FR")
+	if expected := []string{"1.0.0.0-1.0.4.255", "1.0.6.0-1.0.255.255"}; !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Incorrect FR ranges after the split. Expected: %v, Got: %v", expected, ranges)
+	}
+
+	for ip, expected := range map[string]string{"1.0.1.1": "FR", "1.0.5.1": "DE", "1.0.200.1": "FR"} {
+		country, err := locator.lookupCountryByIP(ip)
+		if err != nil || country != expected {
+			t.Errorf("Incorrect country for %s. Expected: %s, Got: %s (err: %v)", ip, expected, country, err)
+		}
+	}
+
+	// Each part keeps the origin of the split range
+	if p, err := manager.rangeProvenance("1.0.6.0-1.0.255.255"); err != nil || p.Source != UpsertSource || p.Range != "1.0.6.0-1.0.255.255" {
+		t.Errorf("Incorrect provenance of a split part: %+v (err: %v)", p, err)
+	}
+
+	// Re-importing the same file is neither a conflict nor an update
+	report, err = manager.importZoneFile(filePath)
+	if err != nil || report.Totals().Unchanged != 1 || len(report.Conflicts) != 0 {
+		t.Errorf("Re-import should be a no-op. Got: %+v, conflicts: %d, err: %v", report.Totals(), len(report.Conflicts), err)
+	}
+}
+
+func TestImportNestedConflict(t *testing.T) {
+	for _, policy := range []ConflictPolicy{ConflictLastFileWins, ConflictMostSpecificWins} {
+		t.Run(policy.String(), func(t *testing.T) {
+			manager, tempDir, cleanup := setupTestDB(t)
+			defer cleanup()
+
+			manager.ConflictPolicy = policy
+
+			// AA.zone is imported first: the nested DE range must not wipe out the rest of the AA one
+			createTestZoneFile(tempDir, "AA", []string{"1.0.0.0/16"})
+			createTestZoneFile(tempDir, "DE", []string{"1.0.5.0/24"})
+			if _, err := manager.importZoneDirectory(tempDir); err != nil {
+				t.Fatalf("Error processing directory: %v", err)
+			}
+
+			locator := newIPLocator(manager, 0)
+			for ip, expected := range map[string]string{"1.0.1.1": "AA", "1.0.5.1": "DE", "1.0.255.1": "AA"} {
+				country, err := locator.lookupCountryByIP(ip)
+				if err != nil || country != expected {
+					t.Errorf("Incorrect country for %s. Expected: %s, Got: %q (err: %v)", ip, expected, country, err)
+				}
+			}
+
+			if report, err := manager.checkIndex(false); err != nil || !report.Consistent() {
+				t.Errorf("Inconsistent index after the split: %+v (err: %v)", report, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+
+	"go.etcd.io/bbolt"
+)
+
+// IndexReport décrit l'écart entre le bucket texte (référence) et les index dérivés:
+// buckets numériques IPv4 / IPv6, index des /16 (ip_prefix_index) et index par pays (ip_ranges_country).
+// Les plages sont données sous leur forme texte (Missing, Mismatched, Unparseable) ou "start-end" (Orphaned, Overlapping, Inverted).
+type IndexReport struct {
+	TextRanges    int
+	NumericRanges int
+
+	Missing        []string // Plages texte absentes de l'index numérique
+	Mismatched     []string // Plages texte associées à un autre pays dans l'index numérique
+	Orphaned       []string // Plages numériques sans plage texte correspondante
+	Overlapping    []string // Plages numériques commençant dans une plage précédente, que la recherche ne voit plus au-delà
+	Inverted       []string // Plages texte ou numériques dont le début est supérieur à la fin (jamais résolues)
+	Unparseable    []string // Clés texte impossibles à parser (ignorées, jamais modifiées)
+	StalePrefixes  int      // Entrées /16 de ip_prefix_index à recalculer
+	StaleCountries int      // Entrées de l'index par pays (ip_ranges_country) manquantes ou en trop
+
+	// Repaired indique que les écarts ont été corrigés dans la même transaction.
+	Repaired bool
+}
+
+// Consistent indique l'absence d'écart (hors clés texte impossibles à parser).
+func (r *IndexReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Mismatched) == 0 && len(r.Orphaned) == 0 && len(r.Overlapping) == 0 &&
+		len(r.Inverted) == 0 && r.StalePrefixes == 0 && r.StaleCountries == 0
+}
+
+// checkIndex compare le bucket texte aux index numériques, à l'index des /16 et à l'index par pays.
+// Avec repair, les index sont reconstruits à partir du bucket texte dans une seule transaction:
+// plages manquantes ou divergentes réécrites, plages orphelines supprimées (avec leur provenance),
+// plages inversées supprimées, plages qui se recouvrent découpées (voir flattenRanges), index des /16 et par pays reconstruits. Retourne ErrReadOnly si repair est demandé sur une base en lecture seule.
+func (m *DBManager) checkIndex(repair bool) (*IndexReport, error) {
+	if repair {
+		if err := m.checkWritable(); err != nil {
+			return nil, err
+		}
+	}
+
+	var report *IndexReport
+	check := func(tx *bbolt.Tx) error {
+		report = &IndexReport{}
+		return m.checkIndexTx(tx, report, repair)
+	}
+
+	var err error
+	if repair {
+		err = m.DB.Update(check)
+	} else {
+		err = m.DB.View(check)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !report.Consistent() {
+		m.logger.Warn("numeric index inconsistent",
+			"path", m.DBPath,
+			"missing", len(report.Missing),
+			"mismatched", len(report.Mismatched),
+			"orphaned", len(report.Orphaned),
+			"overlapping", len(report.Overlapping),
+			"inverted", len(report.Inverted),
+			"stale_prefixes", report.StalePrefixes,
+			"stale_countries", report.StaleCountries,
+			"repaired", report.Repaired,
+		)
+	}
+	if len(report.Unparseable) > 0 {
+		m.logger.Warn("unparseable text ranges", "path", m.DBPath, "count", len(report.Unparseable))
+	}
+	if report.Repaired {
+		m.notifyChange()
+	}
+
+	return report, nil
+}
+
+// checkIndexTx effectue la vérification (et la réparation) dans tx.
+func (m *DBManager) checkIndexTx(tx *bbolt.Tx, report *IndexReport, repair bool) error {
+	buckets := make(map[string]*bbolt.Bucket)
+	for _, name := range liveBuckets.names() {
+		if buckets[name] = tx.Bucket([]byte(name)); buckets[name] == nil {
+			return bucketMissing(name)
+		}
+	}
+	text, numeric, index := buckets[liveBuckets.text], buckets[liveBuckets.numeric], buckets[liveBuckets.index]
+
+	// Text ranges are the reference
+	type fix struct {
+		key     []byte
+		country []byte
+	}
+	var fixes []fix
+	textKeys := make(map[string]bool)
+
+	// Inverted ranges are reported once, whichever bucket holds them
+	var invertedTexts, invertedKeys [][]byte
+	inverted := make(map[string]bool)
+	addInverted := func(key []byte) {
+		if !inverted[string(key)] {
+			inverted[string(key)] = true
+			report.Inverted = append(report.Inverted, formatRangeKey(key))
+		}
+	}
+
+	err := text.ForEach(func(k, v []byte) error {
+		report.TextRanges++
+
+		key, err := parseRangeKey(string(k))
+		if err != nil {
+			report.Unparseable = append(report.Unparseable, string(k))
+			return nil
+		}
+		if invertedRange(key) {
+			addInverted(key)
+			invertedTexts = append(invertedTexts, append([]byte(nil), k...))
+			return nil
+		}
+		textKeys[string(key)] = true
+
+		existing := buckets[liveBuckets.numericFor(key)].Get(key)
+		switch {
+		case existing == nil:
+			report.Missing = append(report.Missing, string(k))
+		case !bytes.Equal(existing, v):
+			report.Mismatched = append(report.Mismatched, string(k))
+		default:
+			return nil
+		}
+		fixes = append(fixes, fix{key, append([]byte(nil), v...)})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var orphans [][]byte
+	for _, name := range []string{liveBuckets.numeric, liveBuckets.numeric6} {
+		// Lookups take the range with the greatest start: one starting inside an earlier range hides the rest of it
+		var maxEnd []byte
+		err := buckets[name].ForEach(func(k, _ []byte) error {
+			report.NumericRanges++
+			if invertedRange(k) {
+				addInverted(k)
+				invertedKeys = append(invertedKeys, append([]byte(nil), k...))
+				return nil
+			}
+			if !textKeys[string(k)] {
+				report.Orphaned = append(report.Orphaned, formatRangeKey(k))
+				orphans = append(orphans, append([]byte(nil), k...))
+			}
+
+			width := len(k) / 2
+			if maxEnd != nil && len(maxEnd) == width && bytes.Compare(k[:width], maxEnd) <= 0 {
+				report.Overlapping = append(report.Overlapping, formatRangeKey(k))
+			}
+			if maxEnd == nil || len(maxEnd) != width || bytes.Compare(k[width:], maxEnd) > 0 {
+				maxEnd = append([]byte(nil), k[width:]...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	report.StalePrefixes = stalePrefixes(index, numeric)
+	report.StaleCountries = staleCountryEntries(text, buckets[liveBuckets.country])
+
+	if !repair || report.Consistent() {
+		return nil
+	}
+
+	for _, f := range fixes {
+		if err := buckets[liveBuckets.numericFor(f.key)].Put(f.key, f.country); err != nil {
+			return err
+		}
+	}
+	for _, k := range invertedTexts {
+		if err := text.Delete(k); err != nil {
+			return err
+		}
+	}
+	for _, k := range append(orphans, invertedKeys...) {
+		if err := buckets[liveBuckets.numericFor(k)].Delete(k); err != nil {
+			return err
+		}
+		if err := buckets[liveBuckets.meta].Delete(k); err != nil {
+			return err
+		}
+	}
+	// Trimming overlaps finds older text forms through the country index
+	if err := rebuildCountryIndex(tx, liveBuckets.text, liveBuckets.country); err != nil {
+		return err
+	}
+	if err := flattenRanges(tx); err != nil {
+		return err
+	}
+	if err := rebuildPrefixIndex(tx, liveBuckets.numeric, liveBuckets.index); err != nil {
+		return err
+	}
+
+	report.Repaired = true
+	return nil
+}
+
+// flattenRanges découpe les plages numériques live qui se recouvrent, comme le ferait un upsert de la plus
+// spécifique: une plage contenue dans une autre est conservée et la plage englobante réduite à ses parties
+// hors d'elle; entre deux plages qui se chevauchent partiellement, celle qui commence le plus tard l'emporte.
+func flattenRanges(tx *bbolt.Tx) error {
+	r, err := openLiveRanges(tx)
+	if err != nil {
+		return err
+	}
+	// A part with the bounds of another range is covered by it: that range is the more specific one
+	r.dropTaken = true
+
+	for _, numeric := range []*bbolt.Bucket{r.numeric, r.numeric6} {
+		var from []byte
+		for {
+			prev, next := firstOverlap(numeric, from)
+			if prev == nil {
+				break
+			}
+
+			// Ranges before prev are disjoint and end before it: resume there
+			from = prev
+			if rangeContains(next, prev) {
+				_, err = r.trim(next, string(numeric.Get(next)), prev)
+			} else {
+				_, err = r.trim(prev, string(numeric.Get(prev)), next)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return r.finish()
+}
+
+// firstOverlap retourne la première paire de plages consécutives de numeric (à partir de la clé from, depuis
+// le début si from est nil) dont la seconde commence avant la fin de la première, ou nil s'il n'y en a pas.
+func firstOverlap(numeric *bbolt.Bucket, from []byte) ([]byte, []byte) {
+	c := numeric.Cursor()
+
+	var k []byte
+	if from == nil {
+		k, _ = c.First()
+	} else {
+		k, _ = c.Seek(from)
+	}
+
+	var prev []byte
+	for ; k != nil; k, _ = c.Next() {
+		// Inverted ranges are never resolved: CheckIndex reports and removes them
+		if invertedRange(k) {
+			continue
+		}
+		width := len(k) / 2
+		if len(prev) == len(k) && bytes.Compare(k[:width], prev[width:]) <= 0 {
+			return prev, append([]byte(nil), k...)
+		}
+		prev = append([]byte(nil), k...)
+	}
+
+	return nil, nil
+}
+
+// stalePrefixes compte les entrées /16 de l'index qui diffèrent d'un recalcul à partir du bucket numérique
+// (entrées divergentes, manquantes ou en trop).
+func stalePrefixes(index, numeric *bbolt.Bucket) int {
+	var touched [1 << prefixIndexBits]bool
+	numeric.ForEach(func(k, _ []byte) error {
+		if len(k) == 8 {
+			for p := uint32(prefixOf(k[:4])); p <= uint32(prefixOf(k[4:])); p++ {
+				touched[p] = true
+			}
+		}
+		return nil
+	})
+
+	stale := 0
+	for p := range touched {
+		if !touched[p] {
+			continue
+		}
+		key := []byte{byte(p >> 8), byte(p)}
+		if !bytes.Equal(index.Get(key), prefixIndexCandidates(numeric, uint16(p))) {
+			stale++
+		}
+	}
+
+	index.ForEach(func(k, _ []byte) error {
+		if len(k) != 2 || !touched[prefixOf(k)] {
+			stale++
+		}
+		return nil
+	})
+
+	return stale
+}
@@ -0,0 +1,257 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestCheckIndex(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	manager, err := openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer func() { manager.closeDatabase() }()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "FR")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("3.0.0.0/24"), "US")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("2001:db8::/32"), "DE")
+
+	report, err := manager.checkIndex(false)
+	if err != nil {
+		t.Fatalf("Error checking index: %v", err)
+	}
+	if !report.Consistent() || report.TextRanges != 3 || report.NumericRanges != 3 {
+		t.Errorf("Expected a consistent index, got %+v", report)
+	}
+
+	// Simulate writes that bypassed the library
+	err = manager.DB.Update(func(tx *bbolt.Tx) error {
+		text := tx.Bucket([]byte("ip_ranges"))
+		if err := text.Put([]byte("2.0.0.0/16"), []byte("CH")); err != nil {
+			return err
+		}
+		if err := text.Put([]byte("2001:db9::/32"), []byte("AT")); err != nil {
+			return err
+		}
+		if err := text.Put([]byte("not-a-range"), []byte("XX")); err != nil {
+			return err
+		}
+		if err := text.Delete([]byte("3.0.0.0/24")); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket([]byte("ip_prefix_index")).Delete([]byte{3, 0}); err != nil {
+			return err
+		}
+
+		start, end, _ := parseIPRange("1.0.0.0/24")
+		return tx.Bucket([]byte("ip_ranges_numeric")).Put(ipv4RangeKey(start, end), []byte("IT"))
+	})
+	if err != nil {
+		t.Fatalf("Error corrupting database: %v", err)
+	}
+
+	locator, err := newMemoryIPLocator(manager, 100)
+	if err != nil {
+		t.Fatalf("Error creating memory locator: %v", err)
+	}
+	if _, err := locator.lookupCountryByIP("2.0.0.1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("A text-only range should not be resolved without the fallback, got %v", err)
+	}
+
+	report, err = manager.checkIndex(false)
+	if err != nil {
+		t.Fatalf("Error checking index: %v", err)
+	}
+	checks := []struct {
+		name     string
+		got      []string
+		expected []string
+	}{
+		{"Missing", report.Missing, []string{"2.0.0.0/16", "2001:db9::/32"}},
+		{"Mismatched", report.Mismatched, []string{"1.0.0.0/24"}},
+		{"Orphaned", report.Orphaned, []string{"3.0.0.0-3.0.0.255"}},
+		{"Unparseable", report.Unparseable, []string{"not-a-range"}},
+	}
+	for _, c := range checks {
+		if len(c.got) != len(c.expected) {
+			t.Errorf("Incorrect %s. Expected: %v, Got: %v", c.name, c.expected, c.got)
+			continue
+		}
+		for i := range c.got {
+			if c.got[i] != c.expected[i] {
+				t.Errorf("Incorrect %s. Expected: %v, Got: %v", c.name, c.expected, c.got)
+			}
+		}
+	}
+	if report.Consistent() || report.Repaired || report.StalePrefixes != 1 || report.StaleCountries != 4 {
+		t.Errorf("Incorrect report: %+v", report)
+	}
+
+	// A read-only database can be checked but not repaired
+	manager.closeDatabase()
+	manager, err = openDatabase(dbPath, true)
+	if err != nil {
+		t.Fatalf("Error opening database read-only: %v", err)
+	}
+	if _, err := manager.checkIndex(true); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly, got %v", err)
+	}
+	manager.closeDatabase()
+
+	manager, err = openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error reopening database: %v", err)
+	}
+	locator, err = newMemoryIPLocator(manager, 100)
+	if err != nil {
+		t.Fatalf("Error creating memory locator: %v", err)
+	}
+	locator.lookupCountryByIP("1.0.0.1")
+
+	report, err = manager.checkIndex(true)
+	if err != nil || !report.Repaired {
+		t.Fatalf("Error repairing index: %+v (err: %v)", report, err)
+	}
+
+	report, err = manager.checkIndex(false)
+	if err != nil || !report.Consistent() {
+		t.Errorf("Index still inconsistent after repair: %+v (err: %v)", report, err)
+	}
+
+	// The repair notifies the locators: the snapshot and the cache are refreshed
+	for ip, expected := range map[string]string{"1.0.0.1": "FR", "2.0.0.1": "CH", "2001:db9::1": "AT", "3.0.0.1": ""} {
+		country, err := locator.lookupCountryByIP(ip)
+		if country != expected || (expected == "") != errors.Is(err, ErrNotFound) {
+			t.Errorf("Incorrect result for %s after repair. Expected: %q, Got: %q (err: %v)", ip, expected, country, err)
+		}
+	}
+}
+
+func TestCheckIndexOverlaps(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Overlapping ranges written by an older version or another tool, with consistent text and indexes
+	err := manager.DB.Update(func(tx *bbolt.Tx) error {
+		text := tx.Bucket([]byte("ip_ranges"))
+		for _, r := range []struct{ rangeText, country string }{
+			{"1.0.0.0/16", "FR"},
+			{"1.0.5.0/24", "DE"},
+			{"1.0.9.0/24", "FR"},
+			{"1.0.200.0-1.1.0.255", "IT"},
+			{"3.0.0.0/16", "FR"},
+			{"3.0.0.0/17", "DE"},
+			{"3.0.128.0/17", "IT"},
+			{"2001:db8::/32", "FR"},
+			{"2001:db8:1::/48", "FR"},
+		} {
+			key, err := parseRangeKey(r.rangeText)
+			if err != nil {
+				return err
+			}
+			if err := text.Put([]byte(r.rangeText), []byte(r.country)); err != nil {
+				return err
+			}
+			numeric := tx.Bucket([]byte(liveBuckets.numericFor(key)))
+			if err := numeric.Put(key, []byte(r.country)); err != nil {
+				return err
+			}
+		}
+		if err := rebuildPrefixIndex(tx, "ip_ranges_numeric", "ip_prefix_index"); err != nil {
+			return err
+		}
+		return rebuildCountryIndex(tx, "ip_ranges", "ip_ranges_country")
+	})
+	if err != nil {
+		t.Fatalf("Error writing overlapping ranges: %v", err)
+	}
+
+	// The end of an enclosing range is hidden by the nested one
+	locator := newIPLocator(manager, 0)
+	if _, err := locator.lookupCountryByIP("1.0.100.1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the outer range to be hidden, got %v", err)
+	}
+
+	report, err := manager.checkIndex(false)
+	if err != nil {
+		t.Fatalf("Error checking index: %v", err)
+	}
+	expected := []string{"1.0.5.0-1.0.5.255", "1.0.9.0-1.0.9.255", "1.0.200.0-1.1.0.255", "3.0.0.0-3.0.255.255", "3.0.128.0-3.0.255.255", "2001:db8:1::-2001:db8:1:ffff:ffff:ffff:ffff:ffff"}
+	if !reflect.DeepEqual(report.Overlapping, expected) || report.Consistent() {
+		t.Errorf("Incorrect Overlapping. Expected: %v, Got: %+v", expected, report)
+	}
+
+	report, err = manager.checkIndex(true)
+	if err != nil || !report.Repaired {
+		t.Fatalf("Error repairing index: %+v (err: %v)", report, err)
+	}
+	report, err = manager.checkIndex(false)
+	if err != nil || !report.Consistent() {
+		t.Errorf("Index still inconsistent after repair: %+v (err: %v)", report, err)
+	}
+
+	// Nested ranges are kept, enclosing ones keep their remainder, the later start wins a partial overlap
+	for ip, expected := range map[string]string{
+		"1.0.0.1": "FR", "1.0.5.1": "DE", "1.0.9.1": "FR", "1.0.100.1": "FR", "1.0.200.1": "IT",
+		"1.1.0.1": "IT", "1.1.1.1": "", "3.0.0.1": "DE", "3.0.200.1": "IT", "2001:db8:1::1": "FR", "2001:db8:2::1": "FR",
+	} {
+		country, err := locator.lookupCountryByIP(ip)
+		if country != expected || (expected == "") != errors.Is(err, ErrNotFound) {
+			t.Errorf("Incorrect result for %s after repair. Expected: %q, Got: %q (err: %v)", ip, expected, country, err)
+		}
+	}
+	// An enclosing range fully covered by nested ones disappears
+	if ranges, _ := locator.listIPRangesByCountry("DE"); !reflect.DeepEqual(ranges, []string{"1.0.5.0/24", "3.0.0.0/17"}) {
+		t.Errorf("Incorrect DE ranges after repair: %v", ranges)
+	}
+}
+
+func TestCheckIndexInverted(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("2.0.0.0/24"), "DE")
+
+	// Inverted ranges written by an older version: in both buckets, or numeric only
+	err := manager.DB.Update(func(tx *bbolt.Tx) error {
+		key, _ := parseRangeKey("1.0.0.10-1.0.0.1")
+		if err := tx.Bucket([]byte("ip_ranges")).Put([]byte("1.0.0.10-1.0.0.1"), []byte("FR")); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("ip_ranges_numeric")).Put(key, []byte("FR")); err != nil {
+			return err
+		}
+		key6, _ := parseRangeKey("2001:db8::10-2001:db8::1")
+		return tx.Bucket([]byte("ip_ranges_numeric_v6")).Put(key6, []byte("FR"))
+	})
+	if err != nil {
+		t.Fatalf("Error writing inverted ranges: %v", err)
+	}
+
+	report, err := manager.checkIndex(false)
+	if err != nil {
+		t.Fatalf("Error checking index: %v", err)
+	}
+	expected := []string{"1.0.0.10-1.0.0.1", "2001:db8::10-2001:db8::1"}
+	if !reflect.DeepEqual(report.Inverted, expected) || report.Consistent() || len(report.Orphaned) != 0 {
+		t.Errorf("Incorrect Inverted. Expected: %v, Got: %+v", expected, report)
+	}
+
+	if report, err := manager.checkIndex(true); err != nil || !report.Repaired {
+		t.Fatalf("Error repairing index: %+v (err: %v)", report, err)
+	}
+	report, err = manager.checkIndex(false)
+	if err != nil || !report.Consistent() || report.TextRanges != 1 || report.NumericRanges != 1 {
+		t.Errorf("Index still inconsistent after repair: %+v (err: %v)", report, err)
+	}
+	if ranges, _ := newIPLocator(manager, 0).listIPRangesByCountry("FR"); len(ranges) != 0 {
+		t.Errorf("Inverted ranges still listed: %v", ranges)
+	}
+}
@@ -0,0 +1,254 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// Le bucket ip_ranges_country contient un bucket imbriqué par code pays, dont les clés sont les plages
+// texte du pays (même forme que dans ip_ranges) et les valeurs leur clé numérique start|end
+// (vide si la plage texte ne se parse pas). Lister un pays ne parcourt que ses propres plages.
+
+// textRanges regroupe le bucket texte et son index par pays: toute écriture du bucket texte
+// passe par put / delete pour garder l'index à jour.
+type textRanges struct {
+	text      *bbolt.Bucket
+	countries *bbolt.Bucket
+}
+
+// openTextRanges ouvre le bucket texte et l'index par pays d'un ensemble de buckets.
+func openTextRanges(tx *bbolt.Tx, set bucketSet) (textRanges, error) {
+	t := textRanges{
+		text:      tx.Bucket([]byte(set.text)),
+		countries: tx.Bucket([]byte(set.country)),
+	}
+	if t.text == nil {
+		return t, bucketMissing(set.text)
+	}
+	if t.countries == nil {
+		return t, bucketMissing(set.country)
+	}
+	return t, nil
+}
+
+// put associe la plage texte rangeText (clé numérique key) à country et la déplace dans l'index
+// si elle appartenait à un autre pays.
+func (t textRanges) put(rangeText string, key []byte, country string) error {
+	if existing := t.text.Get([]byte(rangeText)); existing != nil {
+		if string(existing) == country {
+			return nil
+		}
+		if err := t.unindex(rangeText, string(existing)); err != nil {
+			return err
+		}
+	}
+
+	if err := t.text.Put([]byte(rangeText), []byte(country)); err != nil {
+		return err
+	}
+	return t.index(rangeText, key, country)
+}
+
+// delete supprime la plage texte rangeText et son entrée d'index.
+func (t textRanges) delete(rangeText string) error {
+	existing := t.text.Get([]byte(rangeText))
+	if existing == nil {
+		return nil
+	}
+
+	if err := t.unindex(rangeText, string(existing)); err != nil {
+		return err
+	}
+	return t.text.Delete([]byte(rangeText))
+}
+
+// deleteKey supprime toutes les plages texte de country dont la clé numérique est key
+// (plages antérieures à l'import, dont la forme texte n'est pas connue) et retourne leur nombre.
+func (t textRanges) deleteKey(key []byte, country string) (int, error) {
+	var matches []string
+
+	if nested := t.countries.Bucket([]byte(country)); nested != nil {
+		c := nested.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if bytes.Equal(v, key) {
+				matches = append(matches, string(k))
+			}
+		}
+	}
+
+	for _, rangeText := range matches {
+		if err := t.delete(rangeText); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(matches), nil
+}
+
+// index ajoute rangeText dans le bucket imbriqué de country.
+func (t textRanges) index(rangeText string, key []byte, country string) error {
+	// bbolt rejects empty bucket names: such ranges stay out of the index
+	if country == "" {
+		return nil
+	}
+
+	nested, err := t.countries.CreateBucketIfNotExists([]byte(country))
+	if err != nil {
+		return fmt.Errorf("error creating country bucket %s: %v", country, err)
+	}
+	if key == nil {
+		key = []byte{}
+	}
+	return nested.Put([]byte(rangeText), key)
+}
+
+// unindex retire rangeText du bucket imbriqué de country, supprimé lorsqu'il devient vide.
+func (t textRanges) unindex(rangeText string, country string) error {
+	nested := t.countries.Bucket([]byte(country))
+	if nested == nil {
+		return nil
+	}
+
+	if err := nested.Delete([]byte(rangeText)); err != nil {
+		return err
+	}
+	if k, _ := nested.Cursor().First(); k == nil {
+		return t.countries.DeleteBucket([]byte(country))
+	}
+	return nil
+}
+
+// rebuildCountryIndex reconstruit entièrement l'index par pays countryName à partir du bucket texte textName.
+func rebuildCountryIndex(tx *bbolt.Tx, textName, countryName string) error {
+	text := tx.Bucket([]byte(textName))
+	if text == nil {
+		return bucketMissing(textName)
+	}
+
+	if err := tx.DeleteBucket([]byte(countryName)); err != nil && err != bbolt.ErrBucketNotFound {
+		return fmt.Errorf("error deleting bucket %s: %v", countryName, err)
+	}
+	countries, err := tx.CreateBucket([]byte(countryName))
+	if err != nil {
+		return fmt.Errorf("error creating bucket %s: %v", countryName, err)
+	}
+
+	t := textRanges{text: text, countries: countries}
+	return text.ForEach(func(k, v []byte) error {
+		key, _ := parseRangeKey(string(k))
+		return t.index(string(k), key, string(v))
+	})
+}
+
+// canonicalizeTextRanges réécrit les clés texte de textName sous leur forme canonique (voir canonicalRange),
+// en fusionnant les formes équivalentes d'une même plage: le pays retenu est celui du bucket numérique
+// lorsqu'il existe. Les clés qui ne se parsent pas sont conservées. L'index par pays doit être reconstruit ensuite.
+func canonicalizeTextRanges(tx *bbolt.Tx, set bucketSet) error {
+	text := tx.Bucket([]byte(set.text))
+	if text == nil {
+		return bucketMissing(set.text)
+	}
+
+	type rewrite struct {
+		from, to string
+		key      []byte
+	}
+	var rewrites []rewrite
+	text.ForEach(func(k, _ []byte) error {
+		key, err := parseRangeKey(string(k))
+		if err != nil {
+			return nil
+		}
+		if canonical := canonicalRange(key); canonical != string(k) {
+			rewrites = append(rewrites, rewrite{string(k), canonical, key})
+		}
+		return nil
+	})
+
+	for _, r := range rewrites {
+		country := text.Get([]byte(r.from))
+		if numeric := tx.Bucket([]byte(set.numericFor(r.key))); numeric != nil {
+			if v := numeric.Get(r.key); v != nil {
+				country = v
+			}
+		}
+		country = append([]byte(nil), country...)
+
+		if err := text.Delete([]byte(r.from)); err != nil {
+			return err
+		}
+		if err := text.Put([]byte(r.to), country); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// staleCountryEntries compte les écarts entre le bucket texte et l'index par pays:
+// plages texte absentes de l'index de leur pays et entrées d'index sans plage texte correspondante.
+func staleCountryEntries(text, countries *bbolt.Bucket) int {
+	stale := 0
+
+	text.ForEach(func(k, v []byte) error {
+		if len(v) == 0 {
+			return nil
+		}
+		if nested := countries.Bucket(v); nested == nil || nested.Get(k) == nil {
+			stale++
+		}
+		return nil
+	})
+
+	countries.ForEach(func(country, _ []byte) error {
+		nested := countries.Bucket(country)
+		if nested == nil {
+			stale++
+			return nil
+		}
+		return nested.ForEach(func(k, _ []byte) error {
+			if !bytes.Equal(text.Get(k), country) {
+				stale++
+			}
+			return nil
+		})
+	})
+
+	return stale
+}
+
+// eachCountryRange appelle fn pour chaque plage texte de country (ordre des clés texte), à partir de
+// la première plage strictement supérieure à after (tout le pays si after est vide).
+// Un retour false de fn arrête le parcours.
+func eachCountryRange(tx *bbolt.Tx, country, after string, fn func(rangeText string) bool) error {
+	countries := tx.Bucket([]byte(liveBuckets.country))
+	if countries == nil {
+		return bucketMissing(liveBuckets.country)
+	}
+
+	nested := countries.Bucket([]byte(country))
+	if nested == nil || country == "" {
+		return nil
+	}
+
+	c := nested.Cursor()
+	var k []byte
+	if after == "" {
+		k, _ = c.First()
+	} else {
+		k, _ = c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, _ = c.Next()
+		}
+	}
+
+	for ; k != nil; k, _ = c.Next() {
+		if !fn(string(k)) {
+			break
+		}
+	}
+
+	return nil
+}
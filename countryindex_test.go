@@ -0,0 +1,148 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestCountryIndex(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := createTestZoneFile(tempDir, "DE", []string{"2.0.0.0/24", "2.0.1.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24", "2.0.1.0-2.0.1.254", "2001:db8::/32"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := manager.importZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	locator := newIPLocator(manager, 0)
+	expect := func(step string, expected map[string][]string) {
+		t.Helper()
+		for country, ranges := range expected {
+			got, err := locator.listIPRangesByCountry(country)
+			if err != nil {
+				t.Fatalf("%s: error listing %s: %v", step, country, err)
+			}
+			if !reflect.DeepEqual(got, ranges) {
+				t.Errorf("%s: incorrect ranges for %s. Expected: %v, Got: %v", step, country, ranges, got)
+			}
+		}
+		if report, err := manager.checkIndex(false); err != nil || report.StaleCountries != 0 {
+			t.Errorf("%s: country index out of sync: %+v (err: %v)", step, report, err)
+		}
+	}
+
+	// The DE range keeps its part outside the overlapping FR one written later
+	expect("import", map[string][]string{
+		"DE": {"2.0.0.0/24", "2.0.1.255/32"},
+		"FR": {"1.0.0.0/24", "2.0.1.0-2.0.1.254", "2001:db8::/32"},
+		"IT": nil,
+	})
+
+	// Reassigning a range moves it between countries
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "IT")
+	expect("upsert", map[string][]string{
+		"FR": {"2.0.1.0-2.0.1.254", "2001:db8::/32"},
+		"IT": {"1.0.0.0/24"},
+	})
+
+	// A synchronization swaps in the staging index, nested buckets included
+	if _, err := manager.syncZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error synchronizing directory: %v", err)
+	}
+	expect("sync", map[string][]string{
+		"DE": {"2.0.0.0/24", "2.0.1.255/32"},
+		"FR": {"1.0.0.0/24", "2.0.1.0-2.0.1.254", "2001:db8::/32"},
+		"IT": nil,
+	})
+}
+
+func TestRangesPage(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var all []string
+	for i := 0; i < 25; i++ {
+		prefix := netip.MustParsePrefix(fmt.Sprintf("3.0.%d.0/24", i))
+		manager.upsertPrefixCountry(prefix, "US")
+	}
+	manager.upsertPrefixCountry(netip.MustParsePrefix("4.0.0.0/24"), "CA")
+
+	locator := newIPLocator(manager, 0)
+	all, _ = locator.listIPRangesByCountry("US")
+	if len(all) != 25 {
+		t.Fatalf("Expected 25 ranges, got %d", len(all))
+	}
+
+	var paged []string
+	after, pages := "", 0
+	for {
+		page, next, err := locator.listIPRangesPage("US", after, 10)
+		if err != nil {
+			t.Fatalf("Error listing page: %v", err)
+		}
+		paged = append(paged, page...)
+		pages++
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	if pages != 3 || !reflect.DeepEqual(paged, all) {
+		t.Errorf("Incorrect pagination: %d pages, %v", pages, paged)
+	}
+
+	// An exact multiple of the page size ends without an empty page
+	if page, next, _ := locator.listIPRangesPage("CA", "", 1); len(page) != 1 || next != "" {
+		t.Errorf("Incorrect last page: %v, next %q", page, next)
+	}
+	if _, _, err := locator.listIPRangesPage("US", "", 0); err == nil {
+		t.Error("A zero page size should be rejected")
+	}
+
+	// Streaming stops at the first callback error
+	errStop := errors.New("stop")
+	var streamed []string
+	err := locator.eachIPRange("US", func(r string) error {
+		streamed = append(streamed, r)
+		if len(streamed) == 5 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) || !reflect.DeepEqual(streamed, all[:5]) {
+		t.Errorf("Incorrect streaming: %v (err: %v)", streamed, err)
+	}
+}
+
+func TestCountryIndexMigration(t *testing.T) {
+	manager, err := openDatabase(createLegacyDB(t), false)
+	if err != nil {
+		t.Fatalf("Error migrating database: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	ranges, err := newIPLocator(manager, 0).listIPRangesByCountry("FR")
+	if err != nil || !reflect.DeepEqual(ranges, []string{"1.0.0.0/24"}) {
+		t.Errorf("Country index not built by the migration: %v (err: %v)", ranges, err)
+	}
+
+	err = manager.DB.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte("ip_ranges_country")).Bucket([]byte("FR")) == nil {
+			t.Error("Missing nested bucket for FR")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
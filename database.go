@@ -2,52 +2,122 @@ package ipcountrylocator
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"go.etcd.io/bbolt"
 )
 
 // DBManager gère l'accès à la base BoltDB (fichier, chemin et opérations de mise à jour).
+// ConflictPolicy s'applique aux chevauchements entre pays et PrivateRangePolicy aux réseaux privés
+// rencontrés pendant les imports.
+// Les abonnés (listeners) sont notifiés après chaque modification des données.
+// Les diagnostics sont émis via logger (voir WithLogger).
+// migration résume la mise à jour du schéma faite à l'ouverture (nil en lecture seule).
+// syncMu sérialise les synchronisations, qui partagent les buckets de staging.
 type DBManager struct {
-	DB     *bbolt.DB
-	DBPath string
+	DB                 *bbolt.DB
+	DBPath             string
+	ConflictPolicy     ConflictPolicy
+	PrivateRangePolicy PrivateRangePolicy
+	readOnly           bool
+	migration          *MigrationReport
+
+	logger Logger
+
+	syncMu sync.Mutex
+
+	listeners    []listener
+	nextListener uint64
+	listenersMu  sync.Mutex
+}
+
+// listener est un abonné aux modifications des données, identifié pour pouvoir être retiré.
+type listener struct {
+	id uint64
+	fn func()
+}
+
+// bucketSet regroupe les noms des buckets texte, numériques (IPv4, IPv6), de provenance, d'index
+// des préfixes IPv4 et d'index par pays ciblés par un import.
+type bucketSet struct {
+	text     string
+	numeric  string
+	numeric6 string
+	meta     string
+	index    string
+	country  string
+}
+
+var (
+	// liveBuckets sont les buckets lus par les recherches.
+	liveBuckets = bucketSet{"ip_ranges", "ip_ranges_numeric", "ip_ranges_numeric_v6", "ip_ranges_meta", "ip_prefix_index", "ip_ranges_country"}
+	// stagingBuckets accueillent un import complet avant bascule atomique (SyncDirectory).
+	stagingBuckets = bucketSet{"ip_ranges_staging", "ip_ranges_numeric_staging", "ip_ranges_numeric_v6_staging", "ip_ranges_meta_staging", "ip_prefix_index_staging", "ip_ranges_country_staging"}
+)
+
+// names retourne les six noms de buckets.
+func (b bucketSet) names() []string {
+	return []string{b.text, b.numeric, b.numeric6, b.meta, b.index, b.country}
+}
+
+// numericFor retourne le bucket numérique correspondant à la famille d'une clé start|end.
+func (b bucketSet) numericFor(key []byte) string {
+	if len(key) == 32 {
+		return b.numeric6
+	}
+	return b.numeric
 }
 
 // openDatabase ouvre (ou crée) la base BoltDB située à dbPath.
 // Paramètres:
 //   - dbPath: chemin du fichier .db
-//   - readOnly: si true ouverture en lecture seule (aucune création de buckets)
+//   - readOnly: si true ouverture en lecture seule (aucune création de buckets, schéma à jour exigé)
+//   - opts: options (verrou, permissions, sync, mmap, politiques d'import, logger, simulation des migrations)
+//
+// En lecture / écriture, les migrations de schéma en attente sont appliquées (voir migrations).
 //
 // Retourne un gestionnaire DBManager initialisé ou une erreur.
-func openDatabase(dbPath string, readOnly bool) (*DBManager, error) {
+func openDatabase(dbPath string, readOnly bool, opts ...DBOption) (*DBManager, error) {
+	dbOpts := newDBOptions(opts)
+
 	options := &bbolt.Options{
-		Timeout:  1 * time.Second,
-		NoSync:   false,
-		ReadOnly: readOnly,
-		PageSize: 4096,
+		Timeout:         dbOpts.lockTimeout,
+		NoSync:          dbOpts.noSync,
+		ReadOnly:        readOnly,
+		PageSize:        dbOpts.pageSize,
+		InitialMmapSize: dbOpts.initialMmapSize,
 	}
 
-	db, err := bbolt.Open(dbPath, 0600, options)
+	db, err := bbolt.Open(dbPath, dbOpts.fileMode, options)
 	if err != nil {
 		return nil, fmt.Errorf("error opening the database: %v", err)
 	}
 
 	manager := &DBManager{
-		DB:     db,
-		DBPath: dbPath,
+		DB:                 db,
+		DBPath:             dbPath,
+		ConflictPolicy:     dbOpts.conflictPolicy,
+		PrivateRangePolicy: dbOpts.privateRanges,
+		readOnly:           readOnly,
+		logger:             dbOpts.logger,
 	}
 
-	if !readOnly {
-		// Create necessary buckets
-		err = manager.ensureBuckets()
-		if err != nil {
-			db.Close()
-			return nil, err
-		}
+	if readOnly {
+		err = manager.checkSchema()
+	} else {
+		// Create missing buckets and bring older files up to date
+		manager.migration, err = manager.migrateSchema(dbOpts.migrationDryRun)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	return manager, nil
@@ -59,82 +129,380 @@ func (m *DBManager) closeDatabase() error {
 	return m.DB.Close()
 }
 
-// ensureBuckets garantit l'existence des buckets nécessaires (compatibilité texte, index numérique, index préfixes).
-// Idempotent: recrée uniquement les buckets manquants.
-// Retourne une erreur si une création échoue.
-func (m *DBManager) ensureBuckets() error {
-	return m.DB.Update(func(tx *bbolt.Tx) error {
-		// Original bucket for compatibility
-		if _, err := tx.CreateBucketIfNotExists([]byte("ip_ranges")); err != nil {
-			return fmt.Errorf("error creating bucket ip_ranges: %v", err)
-		}
-		// Bucket for numeric ranges
-		if _, err := tx.CreateBucketIfNotExists([]byte("ip_ranges_numeric")); err != nil {
-			return fmt.Errorf("error creating bucket ip_ranges_numeric: %v", err)
-		}
-		// Bucket for prefix index
-		if _, err := tx.CreateBucketIfNotExists([]byte("ip_prefix_index")); err != nil {
-			return fmt.Errorf("error creating bucket ip_prefix_index: %v", err)
+// checkWritable retourne ErrReadOnly si la base a été ouverte en lecture seule,
+// ErrSchemaOutdated si des migrations n'ont été que simulées (WithMigrationDryRun).
+func (m *DBManager) checkWritable() error {
+	if m.readOnly {
+		return fmt.Errorf("%w: %s", ErrReadOnly, m.DBPath)
+	}
+	if m.migration != nil && m.migration.DryRun && len(m.migration.Applied) > 0 {
+		return fmt.Errorf("%w: %d pending migrations (dry run)", ErrSchemaOutdated, len(m.migration.Applied))
+	}
+	return nil
+}
+
+// subscribe enregistre une fonction appelée après chaque modification des données et retourne
+// l'identifiant à passer à unsubscribe.
+func (m *DBManager) subscribe(fn func()) uint64 {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	m.nextListener++
+	m.listeners = append(m.listeners, listener{id: m.nextListener, fn: fn})
+	return m.nextListener
+}
+
+// unsubscribe retire l'abonné id (sans effet s'il n'est plus abonné).
+func (m *DBManager) unsubscribe(id uint64) {
+	m.listenersMu.Lock()
+	defer m.listenersMu.Unlock()
+	for i, l := range m.listeners {
+		if l.id == id {
+			m.listeners = append(m.listeners[:i], m.listeners[i+1:]...)
+			return
 		}
-		return nil
-	})
+	}
+}
+
+// notifyChange appelle (de manière synchrone) tous les abonnés.
+func (m *DBManager) notifyChange() {
+	m.listenersMu.Lock()
+	listeners := make([]listener, len(m.listeners))
+	copy(listeners, m.listeners)
+	m.listenersMu.Unlock()
+
+	for _, l := range listeners {
+		l.fn()
+	}
 }
 
 // importZoneDirectory parcourt un dossier et importe chaque fichier *.zone (hors 'zz.zone').
-// Agrège le nombre total de lignes valides lues (processed) et mises à jour (updated).
-// Ignore les fichiers invalides en continuant le traitement.
-// Retourne (processed, updated, error).
-func (m *DBManager) importZoneDirectory(directory string) (int, int, error) {
+// Les chevauchements entre pays sont détectés sur l'ensemble des fichiers et résolus selon ConflictPolicy.
+// Un fichier en échec est consigné dans son FileReport et le traitement continue.
+// Retourne le rapport d'import, ou une erreur si le dossier ne peut pas être parcouru.
+func (m *DBManager) importZoneDirectory(directory string) (*ImportReport, error) {
+	if err := m.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	report := newImportReport()
+
 	files, err := filepath.Glob(filepath.Join(directory, "*.zone"))
 	if err != nil {
-		return 0, 0, fmt.Errorf("error searching for files: %v", err)
+		return nil, fmt.Errorf("error searching for files: %v", err)
 	}
 
-	var totalProcessed, totalUpdated int
+	state := newImportState(m.ConflictPolicy)
 
 	for _, file := range files {
 		// Skip specific files if necessary
 		if !strings.Contains(file, "zz.zone") {
-			processed, updated, err := m.importZoneFile(file)
+			fileReport, err := m.loadZoneFile(file, state)
 			if err != nil {
-				fmt.Printf("Error processing file %s: %v\n", file, err)
-				continue
+				m.logger.Error("zone file import failed", "file", file, "error", err)
 			}
-			totalProcessed += processed
-			totalUpdated += updated
+			report.Files = append(report.Files, fileReport)
 		}
 	}
 
-	return totalProcessed, totalUpdated, nil
+	m.notifyChange()
+
+	report.finish(state)
+	m.logReport("zone directory imported", directory, report)
+
+	return report, nil
+}
+
+// importZoneFile importe un fichier .zone puis notifie les abonnés.
+// Retourne le rapport d'import et l'erreur ayant interrompu le fichier (également dans FileReport.Err).
+func (m *DBManager) importZoneFile(file string) (*ImportReport, error) {
+	if err := m.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	report := newImportReport()
+	state := newImportState(m.ConflictPolicy)
+
+	fileReport, err := m.loadZoneFile(file, state)
+	if err != nil {
+		m.logger.Error("zone file import failed", "file", file, "error", err)
+	}
+	report.Files = append(report.Files, fileReport)
+
+	m.notifyChange()
+
+	report.finish(state)
+	m.logReport("zone file imported", file, report)
+
+	return report, err
+}
+
+// logReport émet le résumé d'un import au niveau Info.
+func (m *DBManager) logReport(msg, path string, report *ImportReport) {
+	totals := report.Totals()
+	m.logger.Info(msg,
+		"path", path,
+		"files", len(report.Files),
+		"parsed", totals.Parsed,
+		"skipped_private", totals.SkippedPrivate,
+		"invalid", totals.Invalid,
+		"inserted", totals.Inserted,
+		"changed", totals.Changed,
+		"unchanged", totals.Unchanged,
+		"rejected", totals.Rejected,
+		"removed", report.Removed,
+		"conflicts", len(report.Conflicts),
+		"duration", report.Duration,
+	)
+}
+
+// syncZoneDirectory remplace intégralement le jeu de données par le contenu des fichiers *.zone d'un dossier.
+// Les fichiers sont importés dans les buckets de staging, puis une unique transaction remplace les buckets
+// live par le staging: les plages absentes des fichiers disparaissent et les lecteurs ne voient jamais
+// d'état partiel. Toute erreur (fichier illisible, écriture) annule la synchronisation sans toucher au live.
+// Deux synchronisations simultanées s'exécutent l'une après l'autre: le staging n'appartient qu'à une seule.
+// Retourne le rapport (Removed: entrées texte supprimées), partiel en cas d'erreur.
+func (m *DBManager) syncZoneDirectory(directory string) (*ImportReport, error) {
+	if err := m.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+
+	report := newImportReport()
+
+	files, err := filepath.Glob(filepath.Join(directory, "*.zone"))
+	if err != nil {
+		return nil, fmt.Errorf("error searching for files: %v", err)
+	}
+
+	// An empty directory would wipe the whole dataset
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no zone files found in %s", directory)
+	}
+
+	if err := m.resetBuckets(stagingBuckets); err != nil {
+		return nil, err
+	}
+
+	state := newImportState(m.ConflictPolicy)
+	state.buckets = stagingBuckets
+	state.strict = true
+
+	for _, file := range files {
+		// Skip specific files if necessary
+		if strings.Contains(file, "zz.zone") {
+			continue
+		}
+
+		fileReport, err := m.loadZoneFile(file, state)
+		report.Files = append(report.Files, fileReport)
+		if err != nil {
+			m.logger.Error("zone directory sync aborted", "path", directory, "file", file, "error", err)
+			m.dropBuckets(stagingBuckets)
+			return report.finish(state), fmt.Errorf("error processing file %s: %v", file, err)
+		}
+	}
+
+	_, removed, err := m.swapBuckets(stagingBuckets, liveBuckets)
+	if err != nil {
+		m.logger.Error("zone directory sync aborted", "path", directory, "error", err)
+		m.dropBuckets(stagingBuckets)
+		return report.finish(state), err
+	}
+	report.Removed = removed
+
+	m.notifyChange()
+
+	report.finish(state)
+	m.logReport("zone directory synchronized", directory, report)
+
+	return report, nil
+}
+
+// resetBuckets supprime puis recrée (vides) les buckets d'un ensemble, par exemple un staging abandonné.
+func (m *DBManager) resetBuckets(set bucketSet) error {
+	return m.DB.Update(func(tx *bbolt.Tx) error {
+		for _, name := range set.names() {
+			if err := tx.DeleteBucket([]byte(name)); err != nil && err != bbolt.ErrBucketNotFound {
+				return fmt.Errorf("error deleting bucket %s: %v", name, err)
+			}
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return fmt.Errorf("error creating bucket %s: %v", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// dropBuckets supprime les buckets d'un ensemble (erreurs ignorées: nettoyage au mieux).
+func (m *DBManager) dropBuckets(set bucketSet) {
+	m.DB.Update(func(tx *bbolt.Tx) error {
+		for _, name := range set.names() {
+			tx.DeleteBucket([]byte(name))
+		}
+		return nil
+	})
+}
+
+// swapBuckets remplace, dans une seule transaction, le contenu des buckets dst par celui des buckets src
+// puis supprime src.
+// Retourne le nombre d'entrées texte nouvelles ou modifiées et supprimées par rapport à dst.
+func (m *DBManager) swapBuckets(src, dst bucketSet) (int, int, error) {
+	updated, removed := 0, 0
+
+	err := m.DB.Update(func(tx *bbolt.Tx) error {
+		srcText := tx.Bucket([]byte(src.text))
+		dstText := tx.Bucket([]byte(dst.text))
+		if srcText == nil {
+			return bucketMissing(src.text)
+		}
+		if dstText == nil {
+			return bucketMissing(dst.text)
+		}
+
+		// Diff the text representations before replacing them
+		err := srcText.ForEach(func(k, v []byte) error {
+			if existing := dstText.Get(k); existing == nil || string(existing) != string(v) {
+				updated++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = dstText.ForEach(func(k, _ []byte) error {
+			if srcText.Get(k) == nil {
+				removed++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		srcNames, dstNames := src.names(), dst.names()
+		for i := range srcNames {
+			if err := replaceBucket(tx, srcNames[i], dstNames[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return updated, removed, err
+}
+
+// replaceBucket recrée le bucket dst avec le contenu de src, puis supprime src.
+func replaceBucket(tx *bbolt.Tx, src, dst string) error {
+	srcBucket := tx.Bucket([]byte(src))
+	if srcBucket == nil {
+		return bucketMissing(src)
+	}
+
+	if err := tx.DeleteBucket([]byte(dst)); err != nil && err != bbolt.ErrBucketNotFound {
+		return fmt.Errorf("error deleting bucket %s: %v", dst, err)
+	}
+
+	dstBucket, err := tx.CreateBucket([]byte(dst))
+	if err != nil {
+		return fmt.Errorf("error creating bucket %s: %v", dst, err)
+	}
+
+	if err := copyBucket(srcBucket, dstBucket); err != nil {
+		return err
+	}
+
+	return tx.DeleteBucket([]byte(src))
+}
+
+// copyBucket copie le contenu de src dans dst, buckets imbriqués compris (index par pays).
+func copyBucket(src, dst *bbolt.Bucket) error {
+	// Keys are copied in order: pack pages fully
+	dst.FillPercent = 1.0
+
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), nested)
+	})
+}
+
+// zoneEntry représente une plage lue dans un fichier .zone: forme texte, clé numérique et origine.
+type zoneEntry struct {
+	Text    string
+	Country string
+	File    string
+	Line    int
+	Key     []byte
+}
+
+// source retourne l'origine de l'entrée pour les rapports de conflit.
+func (e zoneEntry) source() RangeSource {
+	return RangeSource{Range: e.Text, Country: e.Country, File: e.File, Line: e.Line}
 }
 
-// importZoneFile importe un fichier .zone (plages ou CIDR) et écrit les données en base.
-// Retourne (processed: lignes publiques lues, updated: entrées réellement écrites/modifiées, error).
-func (m *DBManager) importZoneFile(file string) (int, int, error) {
+// loadZoneFile lit un fichier .zone (plages ou CIDR) et écrit les données en base.
+// Les conflits et lignes invalides sont ajoutés à state.
+// Une erreur d'écriture interrompt le fichier uniquement en mode strict; sinon seule la première est conservée.
+// Retourne les compteurs du fichier et l'erreur l'ayant interrompu (également dans FileReport.Err).
+func (m *DBManager) loadZoneFile(file string, state *importState) (FileReport, error) {
+	started := time.Now()
+	report := FileReport{File: file}
+
+	fail := func(err error) (FileReport, error) {
+		report.Err = err
+		report.Duration = time.Since(started)
+		return report, err
+	}
+
 	country_code := filepath.Base(file)
 	country_code = country_code[:strings.Index(country_code, ".")]
+	report.Country = country_code
 
 	if country_code == "" {
-		return 0, 0, fmt.Errorf("empty country code for file %s", file)
+		return fail(fmt.Errorf("empty country code for file %s", file))
 	}
 
 	country_file, err := os.Open(file)
 	if err != nil {
-		return 0, 0, fmt.Errorf("error opening file %s: %v", file, err)
+		return fail(fmt.Errorf("error opening file %s: %v", file, err))
 	}
 	defer country_file.Close()
 
-	processed := 0
-	updated := 0
-	skipped := 0
+	line := 0
 
 	// Batch processing
 	const batchSize = 1000
-	batch := make(map[string]string, batchSize)
-	numericBatch := make([]IPRange, 0, batchSize)
+	batch := make([]zoneEntry, 0, batchSize)
+
+	flush := func() error {
+		err := m.writeBatch(batch, state, &report)
+		batch = make([]zoneEntry, 0, batchSize)
+		if err != nil {
+			m.logger.Error("zone batch write failed", "file", file, "line", line, "bucket", state.buckets.text, "error", err)
+			err = fmt.Errorf("error updating batch: %v", err)
+			if report.Err == nil {
+				report.Err = err
+			}
+			if state.strict {
+				return err
+			}
+		}
+		return nil
+	}
 
 	scanner := bufio.NewScanner(country_file)
 	for scanner.Scan() {
+		line++
 		ipRange := strings.TrimSpace(scanner.Text())
 
 		// Skip empty or commented lines
@@ -142,171 +510,463 @@ func (m *DBManager) importZoneFile(file string) (int, int, error) {
 			continue
 		}
 
+		report.Parsed++
+
 		// Check if it's a private or local range
-		if isPrivateOrLocalCIDR(ipRange) {
-			skipped++
+		if m.PrivateRangePolicy == PrivateRangesSkip && isPrivateOrLocalCIDR(ipRange) {
+			m.logger.Debug("private range skipped", "file", file, "line", line, "range", ipRange)
+			report.SkippedPrivate++
 			continue
 		}
 
-		processed++
-
-		// Convert to numeric format
-		start, end, err := parseIPRange(ipRange)
+		// Convert to numeric format (IPv4 first, then IPv6)
+		key, err := parseRangeKey(ipRange)
+		if err == nil && invertedRange(key) {
+			err = fmt.Errorf("%w: start > end", ErrInvalidRange)
+		}
 		if err != nil {
-			skipped++
+			m.logger.Warn("invalid zone line", "file", file, "line", line, "text", ipRange, "error", err)
+			report.Invalid++
+			state.errors = append(state.errors, LineError{
+				File:   file,
+				Line:   line,
+				Text:   ipRange,
+				Reason: err.Error(),
+				Err:    &ParseError{File: file, Line: line, Text: ipRange, Err: err},
+			})
 			continue
 		}
 
+		// The text bucket is keyed on the canonical form: equivalent spellings share one entry
+		canonical := canonicalRange(key)
+		if canonical != ipRange {
+			m.logger.Debug("non-canonical range", "file", file, "line", line, "text", ipRange, "canonical", canonical)
+			report.Rewritten++
+			state.rewrites = append(state.rewrites, RangeRewrite{
+				File:      file,
+				Line:      line,
+				Text:      ipRange,
+				Canonical: canonical,
+			})
+		}
+
 		// Add to batch
-		batch[ipRange] = country_code
-		numericBatch = append(numericBatch, IPRange{
-			Start:   start,
-			End:     end,
+		batch = append(batch, zoneEntry{
+			Text:    canonical,
 			Country: country_code,
+			File:    file,
+			Line:    line,
+			Key:     key,
 		})
 
 		// When the batch is full, commit it to the database
 		if len(batch) >= batchSize {
-			u, err := m.writeBatch(batch, numericBatch)
-			if err != nil {
-				fmt.Printf("Error updating batch: %v\n", err)
+			if err := flush(); err != nil {
+				return fail(err)
 			}
-			updated += u
-			batch = make(map[string]string, batchSize)
-			numericBatch = make([]IPRange, 0, batchSize)
 		}
 	}
 
 	// Commit the last batch if there are remaining data
 	if len(batch) > 0 {
-		u, err := m.writeBatch(batch, numericBatch)
-		if err != nil {
-			fmt.Printf("Error updating last batch: %v\n", err)
+		if err := flush(); err != nil {
+			return fail(err)
 		}
-		updated += u
 	}
 
 	if err := scanner.Err(); err != nil {
-		return processed, updated, fmt.Errorf("error reading file: %v", err)
+		return fail(fmt.Errorf("error reading file: %v", err))
 	}
 
-	return processed, updated, nil
+	report.Duration = time.Since(started)
+	return report, report.Err
 }
 
-// writeBatch applique un lot d'insertions/mises à jour dans les deux représentations:
+// writeBatch applique un lot d'insertions/mises à jour dans les représentations (buckets de state):
 //   - bucket texte "ip_ranges"
 //   - bucket binaire "ip_ranges_numeric" (clé: start|end sur 8 octets big-endian)
+//   - bucket binaire "ip_ranges_numeric_v6" (clé: start|end sur 32 octets big-endian)
 //
-// Retourne le nombre d'entrées mises à jour (texte) et une erreur éventuelle.
-func (m *DBManager) writeBatch(batch map[string]string, numericBatch []IPRange) (int, error) {
-	updated := 0
+// Chaque plage est confrontée aux plages d'autres pays qu'elle chevauche et la politique de state
+// départage chaque chevauchement (voir ConflictPolicy.resolve): la plage perdante est réduite à sa partie
+// hors de la gagnante, découpée si besoin en plages canoniques, dans toutes les représentations.
+// Les plages du même pays sont fusionnées: une plage déjà couverte n'est pas écrite (Unchanged), celles
+// qu'elle chevauche sont réduites à leur partie hors de la plage importée, de sorte que les buckets
+// numériques restent sans chevauchement.
+// Une plage identique déjà en base avant l'import est simplement ré-associée (pas de conflit).
+// Les compteurs Inserted / Changed / Unchanged / Rejected de report sont incrémentés après commit.
+func (m *DBManager) writeBatch(batch []zoneEntry, state *importState, report *FileReport) error {
+	var counts FileReport
+	var conflicts []RangeConflict
+	var sources map[string]*RangeSource
+
 	err := m.DB.Batch(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte("ip_ranges"))
-		numericBucket := tx.Bucket([]byte("ip_ranges_numeric"))
+		// Batch may run the function more than once: state is only merged after commit
+		counts = FileReport{}
+		conflicts = nil
+		sources = make(map[string]*RangeSource)
+
+		sourceOf := func(key []byte) (RangeSource, bool) {
+			if src, ok := sources[string(key)]; ok {
+				if src == nil {
+					return RangeSource{}, false
+				}
+				return *src, true
+			}
+			src, ok := state.sources[string(key)]
+			return src, ok
+		}
 
-		if bucket == nil || numericBucket == nil {
-			return fmt.Errorf("bucket not found")
+		r, err := openRanges(tx, state.buckets)
+		if err != nil {
+			return err
 		}
+		bucket := r.texts.text
 
-		// Store in the original bucket
-		for ipRange, countryCode := range batch {
-			existingCountry := string(bucket.Get([]byte(ipRange)))
-			if existingCountry != countryCode {
-				if err := bucket.Put([]byte(ipRange), []byte(countryCode)); err != nil {
-					return err
+		// Counters compare against the live data, even when writing to staging
+		liveBucket := tx.Bucket([]byte(liveBuckets.text))
+		var liveMeta *bbolt.Bucket
+		if state.buckets != liveBuckets {
+			liveMeta = tx.Bucket([]byte(liveBuckets.meta))
+		}
+
+		// trim reduces a stored range to its parts outside cut, which keep their origin
+		trim := func(o rangeOverlap, cut []byte) error {
+			src, inRun := sourceOf(o.key)
+			parts, err := r.trim(o.key, o.country, cut)
+			if err != nil {
+				return err
+			}
+			sources[string(o.key)] = nil
+			if inRun {
+				for _, part := range parts {
+					partSrc := src
+					partSrc.Range = canonicalRange(part)
+					sources[string(part)] = &partSrc
 				}
-				updated++
 			}
+			return nil
 		}
 
-		// Store numeric ranges
-		for _, ipRange := range numericBatch {
-			key := make([]byte, 8)
-			encodeUint32BE(key[0:4], ipRange.Start)
-			encodeUint32BE(key[4:8], ipRange.End)
+		for _, entry := range batch {
+			numericBucket := r.numericFor(entry.Key)
+
+			// Collect conflicting ranges from other countries and ranges of the same country to merge
+			var overlaps, merged []rangeOverlap
+			var found []RangeConflict
+			covered := false
+			for _, o := range overlappingRanges(numericBucket, entry.Key) {
+				existing, inRun := sourceOf(o.key)
+				switch {
+				case bytes.Equal(o.key, entry.Key) && (o.country == entry.Country || !inRun):
+					// Same range stored before this import: plain re-assignment
+					continue
+				case o.country == entry.Country:
+					if rangeContains(o.key, entry.Key) {
+						covered = true
+					} else {
+						merged = append(merged, o)
+					}
+					continue
+				}
+				if !inRun {
+					existing = RangeSource{Range: formatRangeKey(o.key), Country: o.country}
+				}
+				overlaps = append(overlaps, o)
+				found = append(found, RangeConflict{Existing: existing, Incoming: entry.source()})
+			}
+
+			// Already covered by a range of the same country: nothing to write
+			if covered {
+				counts.Unchanged++
+				continue
+			}
+
+			wins, parts := state.policy.resolve(entry.Key, overlaps)
+			for i := range found {
+				found[i].KeptIncoming = wins[i]
+			}
+			conflicts = append(conflicts, found...)
+
+			if len(parts) == 0 {
+				counts.Rejected++
+				continue
+			}
+
+			// A range split around more specific ones is stored as its canonical parts
+			texts := []string{entry.Text}
+			if len(parts) > 1 || !bytes.Equal(parts[0], entry.Key) {
+				texts = texts[:0]
+				for _, part := range parts {
+					texts = append(texts, canonicalRange(part))
+				}
+			}
+
+			// Counted once per line, before the merged ranges of the same country are trimmed
+			stored := func(rangeText string) []byte {
+				existing := bucket.Get([]byte(rangeText))
+				if existing == nil && liveBucket != nil && state.buckets != liveBuckets {
+					existing = liveBucket.Get([]byte(rangeText))
+				}
+				return existing
+			}
+			switch existing := stored(texts[0]); {
+			case len(texts) > 1 || texts[0] != entry.Text:
+				unchanged := true
+				for _, rangeText := range texts {
+					unchanged = unchanged && string(stored(rangeText)) == entry.Country
+				}
+				if unchanged {
+					counts.Unchanged++
+				} else {
+					counts.Inserted++
+				}
+			case existing == nil:
+				counts.Inserted++
+			case string(existing) != entry.Country:
+				counts.Changed++
+			default:
+				counts.Unchanged++
+			}
 
-			existingValue := numericBucket.Get(key)
-			if existingValue == nil || string(existingValue) != ipRange.Country {
-				if err := numericBucket.Put(key, []byte(ipRange.Country)); err != nil {
+			// Losing ranges and ranges of the same country keep only their part outside the incoming range
+			for i, o := range overlaps {
+				if !wins[i] {
+					continue
+				}
+				if err := trim(o, entry.Key); err != nil {
+					return err
+				}
+			}
+			for _, o := range merged {
+				if err := trim(o, entry.Key); err != nil {
+					return err
+				}
+			}
+
+			for i, part := range parts {
+				// Store in the original bucket
+				if err := r.texts.put(texts[i], part, entry.Country); err != nil {
 					return err
 				}
+
+				// Store the numeric range
+				r.spans.mark(numericBucket, part)
+				existingValue := numericBucket.Get(part)
+				if existingValue == nil || string(existingValue) != entry.Country {
+					if err := numericBucket.Put(part, []byte(entry.Country)); err != nil {
+						return err
+					}
+				}
+
+				// Record where the range comes from; the first import date survives re-imports
+				err := putProvenance(r.meta, liveMeta, part, Provenance{
+					Range:      texts[i],
+					Source:     entry.File,
+					Line:       entry.Line,
+					RunID:      state.runID,
+					ImportedAt: state.importedAt,
+				})
+				if err != nil {
+					return err
+				}
+
+				src := entry.source()
+				src.Range = texts[i]
+				sources[string(part)] = &src
 			}
 		}
 
-		return nil
+		// Recompute each /16 touched by the batch once
+		return r.finish()
 	})
 
-	return updated, err
+	if err == nil {
+		for key, src := range sources {
+			if src == nil {
+				delete(state.sources, key)
+			} else {
+				state.sources[key] = *src
+			}
+		}
+		state.conflicts = append(state.conflicts, conflicts...)
+		for _, c := range conflicts {
+			m.logger.Warn("range conflict",
+				"policy", state.policy.String(),
+				"existing_range", c.Existing.Range,
+				"existing_country", c.Existing.Country,
+				"existing_file", c.Existing.File,
+				"existing_line", c.Existing.Line,
+				"incoming_range", c.Incoming.Range,
+				"incoming_country", c.Incoming.Country,
+				"incoming_file", c.Incoming.File,
+				"incoming_line", c.Incoming.Line,
+				"kept_incoming", c.KeptIncoming,
+			)
+		}
+
+		report.Inserted += counts.Inserted
+		report.Changed += counts.Changed
+		report.Unchanged += counts.Unchanged
+		report.Rejected += counts.Rejected
+	}
+
+	return err
+}
+
+// validateUpsert vérifie une plage fournie à un upsert: ipRange doit se parser, ses bornes être celles de key
+// (start|end, même famille d'adresses), start <= end, et countryCode être un code ISO 3166-1 alpha-2.
+func validateUpsert(ipRange string, key []byte, countryCode string) error {
+	if invertedRange(key) {
+		return fmt.Errorf("%w: %s: start %s > end", ErrInvalidRange, ipRange, formatRangeKey(key))
+	}
+
+	parsed, err := parseRangeKey(ipRange)
+	if err != nil {
+		return &ParseError{Text: ipRange, Err: err}
+	}
+	if !bytes.Equal(parsed, key) {
+		return fmt.Errorf("%w: %s: bounds %s differ from the range (%s)",
+			ErrInvalidRange, ipRange, formatRangeKey(key), formatRangeKey(parsed))
+	}
+
+	if !validCountryCode(countryCode) {
+		return fmt.Errorf("%w: %q for range %s", ErrInvalidCountry, countryCode, ipRange)
+	}
+	return nil
 }
 
-// upsertIPRangeCountry associe (ou ré-associe) une plage à un pays.
+// upsertIPRangeCountry associe (ou ré-associe) une plage à un pays, stockée sous sa forme canonique.
+// Les bornes start / end doivent être celles de ipRange (voir validateUpsert).
 // Retourne true si succès, sinon false + erreur.
 func (m *DBManager) upsertIPRangeCountry(ipRange string, start, end uint32, countryCode string) (bool, error) {
-	success := false
+	if err := m.checkWritable(); err != nil {
+		return false, err
+	}
+	key := ipv4RangeKey(start, end)
+	if err := validateUpsert(ipRange, key, countryCode); err != nil {
+		return false, err
+	}
 
-	err := m.DB.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte("ip_ranges"))
-		numericBucket := tx.Bucket([]byte("ip_ranges_numeric"))
+	return m.upsertKey(key, countryCode)
+}
 
-		if bucket == nil || numericBucket == nil {
-			return fmt.Errorf("bucket not found")
-		}
+// upsertIPv6RangeCountry associe (ou ré-associe) une plage IPv6 à un pays, stockée sous sa forme canonique.
+// Les bornes start / end doivent être celles de ipRange (voir validateUpsert).
+// Retourne true si succès, sinon false + erreur.
+func (m *DBManager) upsertIPv6RangeCountry(ipRange string, start, end [16]byte, countryCode string) (bool, error) {
+	if err := m.checkWritable(); err != nil {
+		return false, err
+	}
+	key := ipv6RangeKey(start, end)
+	if err := validateUpsert(ipRange, key, countryCode); err != nil {
+		return false, err
+	}
 
-		// Update the original bucket
-		if err := bucket.Put([]byte(ipRange), []byte(countryCode)); err != nil {
-			return err
-		}
+	return m.upsertKey(key, countryCode)
+}
 
-		// Update the numeric bucket
-		key := make([]byte, 8)
-		encodeUint32BE(key[0:4], start)
-		encodeUint32BE(key[4:8], end)
+// upsertKey écrit la plage key (start|end) sous sa forme canonique dans les buckets live et leurs index.
+// Les plages qu'elle chevauche sont réduites à leur partie hors de key: la plage écrite l'emporte sur
+// les autres pays et se fond dans une plage du même pays qui la contient déjà (voir liveRanges.upsert).
+func (m *DBManager) upsertKey(key []byte, countryCode string) (bool, error) {
+	// Validated codes are ASCII letters: store them in the upper case used by the indexes
+	countryCode = strings.ToUpper(countryCode)
 
-		if err := numericBucket.Put(key, []byte(countryCode)); err != nil {
-			return err
+	_, err := m.mutate(func(r *liveRanges) (int, error) {
+		if _, err := r.upsert(canonicalRange(key), key, countryCode, true); err != nil {
+			return 0, err
 		}
-
-		success = true
-		return nil
+		return 1, nil
 	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
 
-	return success, err
+// upsertRangeString associe (ou ré-associe) une plage "start-end" ou CIDR, IPv4 ou IPv6, à un pays;
+// les bornes sont dérivées de ipRange.
+func (m *DBManager) upsertRangeString(ipRange string, countryCode string) (bool, error) {
+	if start, end, err := parseIPRange(ipRange); err == nil {
+		return m.upsertIPRangeCountry(ipRange, start, end, countryCode)
+	}
+
+	start, end, err := parseIPv6Range(ipRange)
+	if err != nil {
+		return false, &ParseError{Text: ipRange, Err: err}
+	}
+	return m.upsertIPv6RangeCountry(ipRange, start, end, countryCode)
+}
+
+// upsertPrefixCountry associe (ou ré-associe) un préfixe IPv4 ou IPv6 à un pays.
+// Le préfixe est masqué (1.2.3.4/24 -> 1.2.3.0/24) et stocké sous sa forme CIDR canonique;
+// un préfixe IPv4-mapped est stocké comme IPv4.
+func (m *DBManager) upsertPrefixCountry(prefix netip.Prefix, countryCode string) (bool, error) {
+	if !prefix.IsValid() {
+		return false, &ParseError{Text: prefix.String(), Err: fmt.Errorf("invalid prefix")}
+	}
+
+	prefix = normalizePrefix(prefix)
+	first, last := prefixBounds(prefix)
+
+	if prefix.Addr().Is4() {
+		start, end := first.As4(), last.As4()
+		return m.upsertIPRangeCountry(prefix.String(), decodeUint32BE(start[:]), decodeUint32BE(end[:]), countryCode)
+	}
+
+	return m.upsertIPv6RangeCountry(prefix.String(), first.As16(), last.As16(), countryCode)
 }
 
-// verifyRangeIndexes vérifie l'ordre des plages numériques.
+// verifyRangeIndexes vérifie l'ordre des plages numériques (IPv4 puis IPv6).
 // Retourne le nombre total de plages numérisées et une erreur de lecture éventuelle.
-// Affiche un avertissement si des inversions d'ordre sont détectées.
+// Émet un avertissement (par bucket) si des inversions d'ordre sont détectées.
 func (m *DBManager) verifyRangeIndexes() (int, error) {
 	count := 0
-	var lastStart uint32 = 0
-	var warnings int = 0
+	warnings := make(map[string]int)
 
 	err := m.DB.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte("ip_ranges_numeric"))
-		if bucket == nil {
-			return fmt.Errorf("bucket not found")
+		buckets := []struct {
+			name  string
+			width int
+		}{
+			{"ip_ranges_numeric", 4},
+			{"ip_ranges_numeric_v6", 16},
 		}
 
-		c := bucket.Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			if len(k) >= 4 {
-				start := decodeUint32BE(k[0:4])
-
-				// Check that ranges are sorted by start address
-				if count > 0 && start < lastStart {
-					warnings++
+		for _, b := range buckets {
+			bucket := tx.Bucket([]byte(b.name))
+			if bucket == nil {
+				// The IPv6 bucket is missing from databases created before IPv6 support
+				if b.name == "ip_ranges_numeric_v6" {
+					continue
 				}
+				return bucketMissing(b.name)
+			}
+
+			var lastStart []byte
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if len(k) >= b.width {
+					start := k[0:b.width]
 
-				lastStart = start
-				count++
+					// Check that ranges are sorted by start address
+					if lastStart != nil && bytes.Compare(start, lastStart) < 0 {
+						warnings[b.name]++
+					}
+
+					lastStart = start
+					count++
+				}
 			}
 		}
 
 		return nil
 	})
 
-	if warnings > 0 {
-		fmt.Printf("Warning: %d IP ranges are not correctly sorted\n", warnings)
+	for name, n := range warnings {
+		m.logger.Warn("IP ranges are not correctly sorted", "bucket", name, "count", n)
 	}
 
 	return count, err
@@ -1,15 +1,20 @@
 package ipcountrylocator
 
 import (
+	"errors"
+	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 
 	"go.etcd.io/bbolt"
 )
 
 // setupTestDB crée une base temporaire isolée pour un test et retourne un gestionnaire + dossier + fonction de nettoyage.
-func setupTestDB(t *testing.T) (*DBManager, string, func()) {
+func setupTestDB(t testing.TB) (*DBManager, string, func()) {
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "ip-country-test")
 	if err != nil {
@@ -65,7 +70,7 @@ func TestCreateBuckets(t *testing.T) {
 
 	// Check that buckets exist
 	err := manager.DB.View(func(tx *bbolt.Tx) error {
-		buckets := []string{"ip_ranges", "ip_ranges_numeric", "ip_prefix_index"}
+		buckets := []string{"ip_ranges", "ip_ranges_numeric", "ip_ranges_numeric_v6", "ip_prefix_index"}
 		for _, name := range buckets {
 			bucket := tx.Bucket([]byte(name))
 			if bucket == nil {
@@ -97,19 +102,21 @@ func TestProcessFile(t *testing.T) {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
-	processed, updated, err := manager.importZoneFile(filePath)
+	report, err := manager.importZoneFile(filePath)
 	if err != nil {
 		t.Fatalf("Error processing file: %v", err)
 	}
 
+	totals := report.Totals()
+
 	// Check that public IP ranges were processed
-	if processed != 3 { // 2 public ranges + 1 private
-		t.Errorf("Incorrect number of processed ranges. Expected: 3, Got: %d", processed)
+	if totals.Parsed != 3 { // 2 public ranges + 1 private
+		t.Errorf("Incorrect number of processed ranges. Expected: 3, Got: %d", totals.Parsed)
 	}
 
 	// Check that IP ranges were updated
-	if updated < 2 {
-		t.Errorf("Incorrect number of updates. Expected at least: 2, Got: %d", updated)
+	if totals.Inserted < 2 {
+		t.Errorf("Incorrect number of updates. Expected at least: 2, Got: %d", totals.Inserted)
 	}
 
 	// Check that data was correctly stored
@@ -119,7 +126,8 @@ func TestProcessFile(t *testing.T) {
 			return nil
 		}
 
-		country := string(bucket.Get([]byte("1.0.0.0-1.0.0.255")))
+		// Stored under its canonical form
+		country := string(bucket.Get([]byte("1.0.0.0/24")))
 		if country != "FR" {
 			t.Errorf("Incorrect country for range. Expected: FR, Got: %s", country)
 		}
@@ -132,6 +140,58 @@ func TestProcessFile(t *testing.T) {
 	}
 }
 
+func TestProcessFileIPv6(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Create a test file mixing both families
+	ranges := []string{
+		"1.0.0.0-1.0.0.255",
+		"2001:db8::/32",
+		"2a00:1450::1-2a00:1450::ff",
+		"fc00::/7", // Unique local range that will be ignored
+		"not-a-range",
+	}
+
+	filePath, err := createTestZoneFile(tempDir, "FR", ranges)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	report, err := manager.importZoneFile(filePath)
+	if err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+
+	totals := report.Totals()
+	if totals.Parsed != 5 || totals.SkippedPrivate != 1 || totals.Invalid != 1 {
+		t.Errorf("Incorrect counters. Expected parsed/private/invalid: 5/1/1, Got: %d/%d/%d",
+			totals.Parsed, totals.SkippedPrivate, totals.Invalid)
+	}
+
+	if totals.Inserted != 3 {
+		t.Errorf("Incorrect number of updates. Expected: 3, Got: %d", totals.Inserted)
+	}
+
+	// Check that IPv6 ranges landed in the IPv6 numeric bucket
+	err = manager.DB.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("ip_ranges_numeric_v6"))
+		if bucket == nil {
+			t.Fatal("The bucket ip_ranges_numeric_v6 is missing")
+		}
+
+		if n := bucket.Stats().KeyN; n != 2 {
+			t.Errorf("Incorrect number of IPv6 ranges. Expected: 2, Got: %d", n)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Error when checking data: %v", err)
+	}
+}
+
 func TestProcessDirectory(t *testing.T) {
 	manager, tempDir, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -150,15 +210,19 @@ func TestProcessDirectory(t *testing.T) {
 		}
 	}
 
-	processed, _, err := manager.importZoneDirectory(tempDir)
+	report, err := manager.importZoneDirectory(tempDir)
 	if err != nil {
 		t.Fatalf("Error processing directory: %v", err)
 	}
 
 	// Check that files were correctly processed (without zz.zone)
-	if processed != 4 { // 2 ranges for FR + 2 ranges for DE
+	if processed := report.Totals().Parsed; processed != 4 { // 2 ranges for FR + 2 ranges for DE
 		t.Errorf("Incorrect number of processed ranges. Expected: 4, Got: %d", processed)
 	}
+
+	if len(report.Files) != 2 {
+		t.Errorf("Incorrect number of file reports. Expected: 2, Got: %d", len(report.Files))
+	}
 }
 
 func TestUpdateIPRangeCountry(t *testing.T) {
@@ -185,7 +249,7 @@ func TestUpdateIPRangeCountry(t *testing.T) {
 			return nil
 		}
 
-		country := string(bucket.Get([]byte(ipRange)))
+		country := string(bucket.Get([]byte("1.0.0.0/24")))
 		if country != "FR" {
 			t.Errorf("Incorrect country for range. Expected: FR, Got: %s", country)
 		}
@@ -198,6 +262,79 @@ func TestUpdateIPRangeCountry(t *testing.T) {
 	}
 }
 
+func TestUpsertValidation(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start, end, _ := parseIPRange("1.0.0.0/24")
+	start6, end6, _ := parseIPv6Range("2001:db8::/32")
+	var parseErr *ParseError
+
+	cases := []struct {
+		name  string
+		err   error
+		check func(error) bool
+	}{
+		{"inverted bounds", upsertErr(manager.upsertIPRangeCountry("1.0.0.255-1.0.0.0", end, start, "FR")), isErr(ErrInvalidRange)},
+		{"inverted range string", upsertErr(manager.upsertRangeString("1.0.0.255-1.0.0.0", "FR")), isErr(ErrInvalidRange)},
+		{"bounds differ", upsertErr(manager.upsertIPRangeCountry("1.0.0.0/24", start, end+1, "FR")), isErr(ErrInvalidRange)},
+		{"wrong family", upsertErr(manager.upsertIPv6RangeCountry("1.0.0.0/24", start6, end6, "FR")), isErr(ErrInvalidRange)},
+		{"unparseable", upsertErr(manager.upsertIPRangeCountry("bogus", start, end, "FR")), func(err error) bool { return errors.As(err, &parseErr) }},
+		{"unparseable string", upsertErr(manager.upsertRangeString("bogus", "FR")), func(err error) bool { return errors.As(err, &parseErr) }},
+		{"unknown country", upsertErr(manager.upsertIPRangeCountry("1.0.0.0/24", start, end, "XX")), isErr(ErrInvalidCountry)},
+		{"long country", upsertErr(manager.upsertIPv6RangeCountry("2001:db8::/32", start6, end6, "FRA")), isErr(ErrInvalidCountry)},
+		{"prefix country", upsertErr(manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "")), isErr(ErrInvalidCountry)},
+	}
+	for _, c := range cases {
+		if !c.check(c.err) {
+			t.Errorf("%s: unexpected error %v", c.name, c.err)
+		}
+	}
+
+	if ranges, _ := newIPLocator(manager, 0).listIPRangesByCountry("FR"); len(ranges) != 0 {
+		t.Errorf("Rejected upserts were written: %v", ranges)
+	}
+
+	// Valid inputs: lower-case and reserved codes are accepted, bounds derived from the string
+	for _, c := range []struct{ ipRange, country string }{
+		{"1.0.0.0/24", "fr"},
+		{"1.0.1.0-1.0.1.255", "EU"},
+		{"2001:db8::/32", "DE"},
+	} {
+		if ok, err := manager.upsertRangeString(c.ipRange, c.country); !ok || err != nil {
+			t.Errorf("Error upserting %s: %v", c.ipRange, err)
+		}
+	}
+	if report, err := manager.checkIndex(false); err != nil || !report.Consistent() || report.NumericRanges != 3 {
+		t.Errorf("Inconsistent index after upserts: %+v (err: %v)", report, err)
+	}
+
+	// Codes are stored upper-case, whatever the case given
+	locator := newIPLocator(manager, 0)
+	if ranges, _ := locator.listIPRangesByCountry("FR"); !reflect.DeepEqual(ranges, []string{"1.0.0.0/24"}) {
+		t.Errorf("Lower-case upsert not listed under FR: %v", ranges)
+	}
+	if country, err := locator.lookupCountryByIP("1.0.0.1"); err != nil || country != "FR" {
+		t.Errorf("Incorrect country for 1.0.0.1. Expected: FR, Got: %q (err: %v)", country, err)
+	}
+	if count, err := manager.reassignCountry("EU", "be"); err != nil || count != 1 {
+		t.Errorf("ReassignCountry: expected 1 range, got %d (err: %v)", count, err)
+	}
+	if ranges, _ := locator.listIPRangesByCountry("BE"); !reflect.DeepEqual(ranges, []string{"1.0.1.0/24"}) {
+		t.Errorf("Lower-case reassignment not listed under BE: %v", ranges)
+	}
+}
+
+// upsertErr ne conserve que l'erreur d'un upsert.
+func upsertErr(_ bool, err error) error {
+	return err
+}
+
+// isErr retourne un test errors.Is sur target.
+func isErr(target error) func(error) bool {
+	return func(err error) bool { return errors.Is(err, target) }
+}
+
 func TestVerifyIndexes(t *testing.T) {
 	manager, _, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -220,12 +357,249 @@ func TestVerifyIndexes(t *testing.T) {
 		}
 	}
 
+	// IPv6 ranges are counted as well
+	start6, end6, _ := parseIPv6Range("2001:db8::/32")
+	if _, err := manager.upsertIPv6RangeCountry("2001:db8::/32", start6, end6, "FR"); err != nil {
+		t.Fatalf("Error adding IPv6 range: %v", err)
+	}
+
 	count, err := manager.verifyRangeIndexes()
 	if err != nil {
 		t.Fatalf("Error verifying indexes: %v", err)
 	}
 
-	if count != 3 {
-		t.Errorf("Incorrect index count. Expected: 3, Got: %d", count)
+	if count != 4 {
+		t.Errorf("Incorrect index count. Expected: 4, Got: %d", count)
+	}
+}
+
+func TestSyncDirectory(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	zoneDir := filepath.Join(tempDir, "zones")
+	if err := os.Mkdir(zoneDir, 0755); err != nil {
+		t.Fatalf("Failed to create zone directory: %v", err)
+	}
+
+	// Initial dataset
+	for country, ranges := range map[string][]string{
+		"FR": {"1.0.0.0/24", "2.0.0.0/24"},
+		"DE": {"3.0.0.0/24", "2001:db8::/32"},
+	} {
+		if _, err := createTestZoneFile(zoneDir, country, ranges); err != nil {
+			t.Fatalf("Failed to create test file for %s: %v", country, err)
+		}
+	}
+
+	if _, err := manager.importZoneDirectory(zoneDir); err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	// Upstream drops 2.0.0.0/24 and the IPv6 range, adds 4.0.0.0/24
+	for country, ranges := range map[string][]string{
+		"FR": {"1.0.0.0/24"},
+		"DE": {"3.0.0.0/24", "4.0.0.0/24"},
+	} {
+		if _, err := createTestZoneFile(zoneDir, country, ranges); err != nil {
+			t.Fatalf("Failed to create test file for %s: %v", country, err)
+		}
+	}
+
+	report, err := manager.syncZoneDirectory(zoneDir)
+	if err != nil {
+		t.Fatalf("Error synchronizing directory: %v", err)
+	}
+
+	totals := report.Totals()
+	if totals.Parsed != 3 || totals.Inserted != 1 || totals.Unchanged != 2 || report.Removed != 2 {
+		t.Errorf("Incorrect counters. Expected parsed/inserted/unchanged/removed: 3/1/2/2, Got: %d/%d/%d/%d",
+			totals.Parsed, totals.Inserted, totals.Unchanged, report.Removed)
+	}
+
+	err = manager.DB.View(func(tx *bbolt.Tx) error {
+		expected := map[string]int{"ip_ranges": 3, "ip_ranges_numeric": 3, "ip_ranges_numeric_v6": 0}
+		for name, count := range expected {
+			bucket := tx.Bucket([]byte(name))
+			if bucket == nil {
+				t.Fatalf("The bucket %s is missing after sync", name)
+			}
+			if n := bucket.Stats().KeyN; n != count {
+				t.Errorf("Incorrect number of keys in %s. Expected: %d, Got: %d", name, count, n)
+			}
+		}
+
+		if tx.Bucket([]byte("ip_ranges")).Get([]byte("2.0.0.0/24")) != nil {
+			t.Error("The removed range is still stored")
+		}
+
+		for _, name := range stagingBuckets.names() {
+			if tx.Bucket([]byte(name)) != nil {
+				t.Errorf("The staging bucket %s was not dropped", name)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Error when checking data: %v", err)
+	}
+}
+
+func TestSyncDirectoryConcurrent(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Two upstream snapshots with no range in common
+	dirs := make([]string, 2)
+	for i := range dirs {
+		dirs[i] = filepath.Join(tempDir, fmt.Sprintf("zones%d", i))
+		if err := os.Mkdir(dirs[i], 0755); err != nil {
+			t.Fatalf("Failed to create zone directory: %v", err)
+		}
+		ranges := make([]string, 200)
+		for j := range ranges {
+			ranges[j] = fmt.Sprintf("%d.%d.%d.0/24", 20+i, j/256, j%256)
+		}
+		if _, err := createTestZoneFile(dirs[i], "FR", ranges); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = manager.syncZoneDirectory(dirs[i%2])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Sync %d failed: %v", i, err)
+		}
+	}
+
+	// The live data is exactly one of the snapshots, never a mix of both
+	ranges, _ := newIPLocator(manager, 0).listIPRangesByCountry("FR")
+	first, last := "", ""
+	if len(ranges) > 0 {
+		first, last = ranges[0][:3], ranges[len(ranges)-1][:3]
+	}
+	if len(ranges) != 200 || first != last {
+		t.Errorf("Live data mixes both snapshots: %d ranges from %s to %s", len(ranges), first, last)
+	}
+}
+
+func TestSyncDirectoryFailureKeepsData(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start, end, _ := parseIPRange("1.0.0.0/24")
+	if _, err := manager.upsertIPRangeCountry("1.0.0.0/24", start, end, "FR"); err != nil {
+		t.Fatalf("Error adding IP range: %v", err)
+	}
+
+	// An empty directory must not wipe the dataset
+	if _, err := manager.syncZoneDirectory(tempDir); err == nil {
+		t.Error("Synchronizing an empty directory should fail")
+	}
+
+	// An unreadable zone file aborts the sync
+	if err := os.Mkdir(filepath.Join(tempDir, "DE.zone"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if _, err := createTestZoneFile(tempDir, "FR", []string{"2.0.0.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if _, err := manager.syncZoneDirectory(tempDir); err == nil {
+		t.Error("Synchronizing with an unreadable file should fail")
+	}
+
+	locator := newIPLocator(manager, 100)
+	country, err := locator.lookupCountryByIP("1.0.0.1")
+	if err != nil || country != "FR" {
+		t.Errorf("The live data changed after a failed sync. Expected: FR, Got: %s (err: %v)", country, err)
+	}
+}
+
+func TestUpsertPrefix(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := manager.upsertPrefixCountry(netip.MustParsePrefix("::ffff:9.9.9.0/120"), "CH"); err != nil {
+		t.Fatalf("Error adding prefix: %v", err)
+	}
+
+	err := manager.DB.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket([]byte("ip_ranges")).Get([]byte("9.9.9.0/24")); string(v) != "CH" {
+			t.Errorf("The IPv4-mapped prefix was not stored as IPv4: %q", v)
+		}
+		if v := tx.Bucket([]byte("ip_ranges_numeric")).Get(ipv4RangeKey(0x09090900, 0x090909FF)); string(v) != "CH" {
+			t.Errorf("The numeric key is missing: %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading database: %v", err)
+	}
+
+	var parseErr *ParseError
+	if _, err := manager.upsertPrefixCountry(netip.Prefix{}, "CH"); !errors.As(err, &parseErr) {
+		t.Errorf("Expected a *ParseError for an invalid prefix, got: %v", err)
+	}
+}
+
+func TestCanonicalImport(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	filePath, err := createTestZoneFile(tempDir, "FR", []string{
+		"8.8.8.1/24",
+		"8.8.8.0/24",
+		"8.8.8.0 - 8.8.8.255",
+		"2001:0db8:0000::/32",
+		"5.0.0.1-5.0.0.6",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	report, err := manager.importZoneFile(filePath)
+	if err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+
+	totals := report.Totals()
+	if totals.Inserted != 3 || totals.Unchanged != 2 || totals.Rewritten != 3 {
+		t.Errorf("Incorrect counters: %+v", totals)
+	}
+
+	expected := []RangeRewrite{
+		{File: filePath, Line: 1, Text: "8.8.8.1/24", Canonical: "8.8.8.0/24"},
+		{File: filePath, Line: 3, Text: "8.8.8.0 - 8.8.8.255", Canonical: "8.8.8.0/24"},
+		{File: filePath, Line: 4, Text: "2001:0db8:0000::/32", Canonical: "2001:db8::/32"},
+	}
+	if !reflect.DeepEqual(report.Rewrites, expected) {
+		t.Errorf("Incorrect rewrites. Expected: %+v, Got: %+v", expected, report.Rewrites)
+	}
+
+	// One text entry per numeric range
+	ranges, _ := newIPLocator(manager, 0).listIPRangesByCountry("FR")
+	if want := []string{"2001:db8::/32", "5.0.0.1-5.0.0.6", "8.8.8.0/24"}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("Incorrect stored ranges. Expected: %v, Got: %v", want, ranges)
+	}
+
+	// An upsert under any spelling overwrites the imported entry
+	start, end, _ := parseIPRange("8.8.8.0-8.8.8.255")
+	if _, err := manager.upsertIPRangeCountry("8.8.8.0-8.8.8.255", start, end, "US"); err != nil {
+		t.Fatalf("Error updating IP range: %v", err)
+	}
+	if ranges, _ := newIPLocator(manager, 0).listIPRangesByCountry("US"); !reflect.DeepEqual(ranges, []string{"8.8.8.0/24"}) {
+		t.Errorf("Upsert did not overwrite the canonical entry: %v", ranges)
 	}
 }
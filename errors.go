@@ -0,0 +1,57 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Erreurs sentinelles, à tester avec errors.Is.
+var (
+	// ErrInvalidIP: l'adresse fournie n'est ni une IPv4 ni une IPv6 valide.
+	ErrInvalidIP = errors.New("invalid IP address")
+	// ErrNotFound: aucune plage ne contient l'adresse.
+	ErrNotFound = errors.New("no matching country found")
+	// ErrBucketMissing: un bucket attendu est absent (base non initialisée ou ouverte en lecture seule avant création).
+	ErrBucketMissing = errors.New("bucket not found")
+	// ErrReadOnly: écriture demandée sur une base ouverte en lecture seule.
+	ErrReadOnly = errors.New("database is read-only")
+	// ErrSchemaOutdated: le schéma de la base précède la version courante (migrations en attente).
+	ErrSchemaOutdated = errors.New("database schema is outdated")
+	// ErrSchemaTooNew: la base a été créée par une version plus récente de la bibliothèque.
+	ErrSchemaTooNew = errors.New("database schema is newer than supported")
+	// ErrInvalidCountry: code pays absent de la norme ISO 3166-1 alpha-2.
+	ErrInvalidCountry = errors.New("invalid country code")
+	// ErrInvalidRange: bornes start / end inversées (upsert, Tx, ligne .zone) ou différentes de celles
+	// de la plage texte (UpsertRange).
+	ErrInvalidRange = errors.New("invalid range bounds")
+	// ErrOverlap: une plage écrite par une transaction (DBManager.Tx) chevauche une plage d'un autre pays.
+	ErrOverlap = errors.New("range overlaps another country")
+)
+
+// ParseError décrit une plage impossible à parser, à extraire avec errors.As.
+// File et Line sont renseignés pour une ligne de fichier .zone, vides pour ParseRange / ParseRangeV6.
+// Err contient la cause (format invalide, adresse invalide, mauvaise famille d'adresses).
+type ParseError struct {
+	File string
+	Line int
+	Text string
+	Err  error
+}
+
+// Error retourne "fichier:ligne: invalid range "texte": cause" (sans préfixe hors fichier).
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("invalid range %q: %v", e.Text, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: invalid range %q: %v", e.File, e.Line, e.Text, e.Err)
+}
+
+// Unwrap retourne la cause.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// bucketMissing construit une erreur ErrBucketMissing nommant le bucket.
+func bucketMissing(name string) error {
+	return fmt.Errorf("%w: %s", ErrBucketMissing, name)
+}
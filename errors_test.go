@@ -0,0 +1,125 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestLookupErrors(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	locator := newIPLocator(manager, 100, WithTextFallback())
+
+	if _, err := locator.lookupCountryByIP("bogus"); !errors.Is(err, ErrInvalidIP) {
+		t.Errorf("Expected ErrInvalidIP, got: %v", err)
+	}
+	if _, err := locator.lookupCountryByIP("9.9.9.9"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+
+	// A missing bucket is not reported as a miss
+	err := manager.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket([]byte("ip_ranges"))
+	})
+	if err != nil {
+		t.Fatalf("Error deleting bucket: %v", err)
+	}
+
+	_, err = locator.lookupCountryByIP("9.9.9.9")
+	if !errors.Is(err, ErrBucketMissing) || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrBucketMissing, got: %v", err)
+	}
+	if st := locator.stats(); st.NotFound != 1 {
+		t.Errorf("A missing bucket was counted as not found: %d", st.NotFound)
+	}
+
+	// Without the text fallback, the numeric bucket is the one reported
+	err = manager.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket([]byte("ip_ranges_numeric"))
+	})
+	if err != nil {
+		t.Fatalf("Error deleting bucket: %v", err)
+	}
+
+	_, err = newIPLocator(manager, 100).lookupCountryByIP("9.9.9.9")
+	if !errors.Is(err, ErrBucketMissing) || !strings.Contains(err.Error(), "ip_ranges_numeric") {
+		t.Errorf("Expected ErrBucketMissing for ip_ranges_numeric, got: %v", err)
+	}
+}
+
+func TestReadOnlyErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "test.db")
+
+	manager, err := openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	manager.closeDatabase()
+
+	manager, err = openDatabase(dbPath, true)
+	if err != nil {
+		t.Fatalf("Failed to open read-only database: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	filePath, _ := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24"})
+
+	if _, err := manager.importZoneFile(filePath); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("ImportFile: expected ErrReadOnly, got: %v", err)
+	}
+	if _, err := manager.importZoneDirectory(tempDir); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("ImportDirectory: expected ErrReadOnly, got: %v", err)
+	}
+	if _, err := manager.syncZoneDirectory(tempDir); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("SyncDirectory: expected ErrReadOnly, got: %v", err)
+	}
+	if _, err := manager.upsertIPRangeCountry("1.0.0.0/24", 0x01000000, 0x010000FF, "FR"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("UpsertRange: expected ErrReadOnly, got: %v", err)
+	}
+	if _, err := manager.upsertIPv6RangeCountry("2001:db8::/32", [16]byte{}, [16]byte{}, "FR"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("UpsertRangeV6: expected ErrReadOnly, got: %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var parseErr *ParseError
+
+	_, _, err := ParseRange("1.2.3")
+	if !errors.As(err, &parseErr) || parseErr.Text != "1.2.3" || parseErr.File != "" {
+		t.Errorf("Expected a *ParseError, got: %v", err)
+	}
+	if _, _, err := ParseRangeV6("1.0.0.0/24"); !errors.As(err, &parseErr) || parseErr.Err == nil {
+		t.Errorf("Expected a *ParseError, got: %v", err)
+	}
+
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	filePath, _ := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24", "bogus"})
+	report, err := manager.importZoneFile(filePath)
+	if err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+
+	if !errors.As(report.Err(), &parseErr) {
+		t.Fatalf("Expected a *ParseError in the report, got: %v", report.Err())
+	}
+	if parseErr.File != filePath || parseErr.Line != 2 || parseErr.Text != "bogus" {
+		t.Errorf("Incorrect parse error: %+v", parseErr)
+	}
+	if expected := filePath + `:2: invalid range "bogus": invalid IP range format`; parseErr.Error() != expected {
+		t.Errorf("Incorrect message. Expected: %s, Got: %s", expected, parseErr.Error())
+	}
+
+	// A clean import has no error
+	filePath, _ = createTestZoneFile(tempDir, "DE", []string{"2.0.0.0/24"})
+	if report, _ = manager.importZoneFile(filePath); report.Err() != nil {
+		t.Errorf("Unexpected report error: %v", report.Err())
+	}
+}
@@ -2,7 +2,6 @@ module github.com/FirPic/go-ip-country-resolver
 
 go 1.24.0
 
-require (
-	go.etcd.io/bbolt v1.4.0 // indirect
-	golang.org/x/sys v0.29.0 // indirect
-)
+require go.etcd.io/bbolt v1.4.0
+
+require golang.org/x/sys v0.29.0 // indirect
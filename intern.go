@@ -0,0 +1,46 @@
+package ipcountrylocator
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// countryCodes interne les codes pays lus dans BoltDB: une seule chaîne par code pour tout le paquet.
+// La table est copiée à chaque nouveau code (rare: quelques centaines de codes au plus), ce qui rend
+// la lecture sans verrou ni allocation.
+var countryCodes = struct {
+	table atomic.Pointer[map[string]string]
+	mutex sync.Mutex
+}{}
+
+// internCountry retourne la chaîne internée égale à b, sans allocation si le code est déjà connu.
+// b peut pointer dans la mmap BoltDB: il est copié avant d'être conservé.
+func internCountry(b []byte) string {
+	if table := countryCodes.table.Load(); table != nil {
+		if country, ok := (*table)[string(b)]; ok {
+			return country
+		}
+	}
+
+	countryCodes.mutex.Lock()
+	defer countryCodes.mutex.Unlock()
+
+	current := countryCodes.table.Load()
+	if current != nil {
+		if country, ok := (*current)[string(b)]; ok {
+			return country
+		}
+	}
+
+	next := make(map[string]string)
+	if current != nil {
+		for k, v := range *current {
+			next[k] = v
+		}
+	}
+	country := string(b)
+	next[country] = country
+	countryCodes.table.Store(&next)
+
+	return country
+}
@@ -0,0 +1,122 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"net/netip"
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+// rangeTable est un instantané immuable des buckets numériques, trié par (start, end).
+// Une fois publié il n'est jamais modifié: les lectures concurrentes se font sans verrou.
+type rangeTable struct {
+	v4 []IPRange
+	v6 []IPRange6
+}
+
+// loadRangeTable lit "ip_ranges_numeric" et "ip_ranges_numeric_v6" dans une table compacte.
+// L'ordre des clés BoltDB (start|end big-endian) fournit directement le tri.
+// Les codes pays sont internés pour ne conserver qu'une chaîne par pays.
+func loadRangeTable(db *bbolt.DB) (*rangeTable, error) {
+	table := &rangeTable{}
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte("ip_ranges_numeric"))
+		if bucket == nil {
+			return bucketMissing("ip_ranges_numeric")
+		}
+
+		table.v4 = make([]IPRange, 0, bucket.Stats().KeyN)
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(k) >= 8 {
+				table.v4 = append(table.v4, IPRange{
+					Start:   decodeUint32BE(k[0:4]),
+					End:     decodeUint32BE(k[4:8]),
+					Country: internCountry(v),
+				})
+			}
+		}
+
+		// The IPv6 bucket is missing from databases created before IPv6 support
+		bucket6 := tx.Bucket([]byte("ip_ranges_numeric_v6"))
+		if bucket6 == nil {
+			return nil
+		}
+
+		table.v6 = make([]IPRange6, 0, bucket6.Stats().KeyN)
+		c = bucket6.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(k) >= 32 {
+				var r IPRange6
+				copy(r.Start[:], k[0:16])
+				copy(r.End[:], k[16:32])
+				r.Country = internCountry(v)
+				table.v6 = append(table.v6, r)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// lookupAddr recherche par dichotomie la plage de plus grand start <= addr et vérifie qu'elle contient addr.
+// Même sémantique que seekRange sur les buckets numériques (IPv4-mapped déjà ramenée en IPv4).
+func (t *rangeTable) lookupAddr(addr netip.Addr) (rangeMatch, bool) {
+	if addr.Is4() {
+		b := addr.As4()
+		ipNum := decodeUint32BE(b[:])
+		i := sort.Search(len(t.v4), func(i int) bool { return t.v4[i].Start > ipNum }) - 1
+		if i >= 0 && ipNum <= t.v4[i].End {
+			r := &t.v4[i]
+			var start, end [4]byte
+			encodeUint32BE(start[:], r.Start)
+			encodeUint32BE(end[:], r.End)
+			return rangeMatch{country: r.Country, start: netip.AddrFrom4(start), end: netip.AddrFrom4(end)}, true
+		}
+		return rangeMatch{}, false
+	}
+
+	ip6 := addr.As16()
+	i := sort.Search(len(t.v6), func(i int) bool { return bytes.Compare(t.v6[i].Start[:], ip6[:]) > 0 }) - 1
+	if i >= 0 && bytes.Compare(ip6[:], t.v6[i].End[:]) <= 0 {
+		r := &t.v6[i]
+		return rangeMatch{country: r.Country, start: netip.AddrFrom16(r.Start), end: netip.AddrFrom16(r.End)}, true
+	}
+	return rangeMatch{}, false
+}
+
+// enableMemoryTable charge l'instantané initial, remplacé ensuite après chaque modification
+// des données du DBManager (voir onChange).
+func (l *IPLocator) enableMemoryTable() error {
+	return l.reloadTable()
+}
+
+// reloadTable recharge l'instantané depuis BoltDB puis le publie atomiquement.
+// Les caches (positif et négatif) sont vidés pour ne pas servir de résultats antérieurs au nouvel instantané.
+// En cas d'erreur l'instantané courant reste en place.
+func (l *IPLocator) reloadTable() error {
+	table, err := loadRangeTable(l.DBManager.DB)
+	if err != nil {
+		return err
+	}
+
+	l.table.Store(table)
+	l.Cache.reset()
+	l.NegativeCache.reset()
+	return nil
+}
+
+// reloadTableIfEnabled recharge l'instantané uniquement si le localisateur est en mode mémoire.
+func (l *IPLocator) reloadTableIfEnabled() error {
+	if l.table.Load() == nil {
+		return nil
+	}
+	return l.reloadTable()
+}
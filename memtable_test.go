@@ -0,0 +1,142 @@
+package ipcountrylocator
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestRangeTableLookup(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ipRanges := []struct {
+		ipRange string
+		country string
+	}{
+		{"0.0.0.0-0.255.255.255", "AD"},
+		{"1.0.0.0-1.0.0.255", "FR"},
+		{"8.8.8.0/24", "US"},
+		{"255.255.255.0-255.255.255.255", "ZW"},
+	}
+
+	for _, r := range ipRanges {
+		start, end, _ := parseIPRange(r.ipRange)
+		if _, err := manager.upsertIPRangeCountry(r.ipRange, start, end, r.country); err != nil {
+			t.Fatalf("Error adding IP range: %v", err)
+		}
+	}
+
+	start6, end6, _ := parseIPv6Range("2001:db8::/32")
+	if _, err := manager.upsertIPv6RangeCountry("2001:db8::/32", start6, end6, "DE"); err != nil {
+		t.Fatalf("Error adding IPv6 range: %v", err)
+	}
+
+	table, err := loadRangeTable(manager.DB)
+	if err != nil {
+		t.Fatalf("Error loading range table: %v", err)
+	}
+
+	if len(table.v4) != 4 || len(table.v6) != 1 {
+		t.Fatalf("Incorrect table size. Expected: 4/1, Got: %d/%d", len(table.v4), len(table.v6))
+	}
+
+	testCases := []struct {
+		ip              string
+		expectedCountry string
+		shouldFind      bool
+	}{
+		{"0.0.0.0", "AD", true},
+		{"1.0.0.0", "FR", true},
+		{"1.0.0.255", "FR", true},
+		{"1.0.1.0", "", false},
+		{"8.8.8.8", "US", true},
+		{"::ffff:8.8.8.8", "US", true},
+		{"255.255.255.255", "ZW", true},
+		{"2001:db8::1", "DE", true},
+		{"2001:db9::1", "", false},
+		{"::1", "", false},
+	}
+
+	for _, tc := range testCases {
+		match, found := table.lookupAddr(netip.MustParseAddr(tc.ip).Unmap())
+		country := match.country
+		if found != tc.shouldFind || country != tc.expectedCountry {
+			t.Errorf("Incorrect result for %s. Expected: %s (%v), Got: %s (%v)",
+				tc.ip, tc.expectedCountry, tc.shouldFind, country, found)
+		}
+	}
+}
+
+func TestMemoryLocatorReload(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start, end, _ := parseIPRange("1.0.0.0-1.0.0.255")
+	if _, err := manager.upsertIPRangeCountry("1.0.0.0-1.0.0.255", start, end, "FR"); err != nil {
+		t.Fatalf("Error adding IP range: %v", err)
+	}
+
+	locator, err := newMemoryIPLocator(manager, 100)
+	if err != nil {
+		t.Fatalf("Error creating memory locator: %v", err)
+	}
+
+	country, err := locator.lookupCountryByIP("1.0.0.1")
+	if err != nil || country != "FR" {
+		t.Errorf("Incorrect country for 1.0.0.1. Expected: FR, Got: %s (err: %v)", country, err)
+	}
+
+	// An upsert must swap the snapshot and drop stale cached results
+	if _, err := manager.upsertIPRangeCountry("1.0.0.0-1.0.0.255", start, end, "IT"); err != nil {
+		t.Fatalf("Error updating IP range: %v", err)
+	}
+
+	country, err = locator.lookupCountryByIP("1.0.0.1")
+	if err != nil || country != "IT" {
+		t.Errorf("Snapshot not reloaded after upsert. Expected: IT, Got: %s (err: %v)", country, err)
+	}
+
+	// A directory import must swap the snapshot as well
+	if _, err := createTestZoneFile(tempDir, "DE", []string{"2.0.0.0/24", "2001:db8::/32"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if _, err := locator.lookupCountryByIP("2.0.0.1"); err == nil {
+		t.Error("The search for 2.0.0.1 should fail before import")
+	}
+
+	if _, err := manager.importZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	for _, ip := range []string{"2.0.0.1", "2001:db8::1"} {
+		country, err = locator.lookupCountryByIP(ip)
+		if err != nil || country != "DE" {
+			t.Errorf("Snapshot not reloaded after import for %s. Expected: DE, Got: %s (err: %v)", ip, country, err)
+		}
+	}
+}
+
+// BenchmarkLookupMemory mesure la recherche par dichotomie dans l'instantané mémoire.
+func BenchmarkLookupMemory(b *testing.B) {
+	manager, _, cleanup := setupTestDB(b)
+	defer cleanup()
+
+	populateBenchmarkRanges(b, manager)
+
+	table, err := loadRangeTable(manager.DB)
+	if err != nil {
+		b.Fatalf("Error loading range table: %v", err)
+	}
+
+	for _, ip := range []string{"0.0.0.10", "128.0.0.10", "255.252.0.10"} {
+		ipAddr := netip.MustParseAddr(ip)
+		b.Run(ip, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, found := table.lookupAddr(ipAddr); !found {
+					b.Fatalf("No range found for %s", ip)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// liveRanges regroupe les buckets modifiés par une correction manuelle (upsert, suppression,
+// réaffectation) dans une transaction: les buckets live, ou ceux d'un import (voir openRanges).
+// Les /16 touchés sont recalculés une seule fois par finish; runID et now identifient la transaction
+// dans la provenance des plages écrites. onTrim, s'il est défini, reçoit chaque partie écrite par trim;
+// dropTaken fait ignorer par trim une partie déjà stockée pour un autre pays au lieu d'échouer.
+// changed indique qu'au moins un bucket a été modifié (les compteurs ne portent que sur les plages texte).
+type liveRanges struct {
+	texts     textRanges
+	numeric   *bbolt.Bucket
+	numeric6  *bbolt.Bucket
+	meta      *bbolt.Bucket
+	index     *bbolt.Bucket
+	spans     prefixSpans
+	runID     string
+	now       time.Time
+	onTrim    func(key []byte)
+	dropTaken bool
+	changed   bool
+}
+
+// openLiveRanges ouvre les buckets live dans tx.
+func openLiveRanges(tx *bbolt.Tx) (*liveRanges, error) {
+	return openRanges(tx, liveBuckets)
+}
+
+// openRanges ouvre les buckets de set dans tx.
+func openRanges(tx *bbolt.Tx, set bucketSet) (*liveRanges, error) {
+	texts, err := openTextRanges(tx, set)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &liveRanges{texts: texts, runID: newRunID(), now: time.Now()}
+	for _, b := range []struct {
+		name   string
+		bucket **bbolt.Bucket
+	}{
+		{set.numeric, &r.numeric},
+		{set.numeric6, &r.numeric6},
+		{set.meta, &r.meta},
+		{set.index, &r.index},
+	} {
+		if *b.bucket = tx.Bucket([]byte(b.name)); *b.bucket == nil {
+			return nil, bucketMissing(b.name)
+		}
+	}
+
+	return r, nil
+}
+
+// numericFor retourne le bucket numérique de la famille de key.
+func (r *liveRanges) numericFor(key []byte) *bbolt.Bucket {
+	if len(key) == 32 {
+		return r.numeric6
+	}
+	return r.numeric
+}
+
+// removeKey supprime la plage numérique key et sa provenance.
+func (r *liveRanges) removeKey(key []byte) error {
+	numeric := r.numericFor(key)
+	if numeric.Get(key) == nil {
+		return nil
+	}
+
+	r.spans.mark(numeric, key)
+	r.changed = true
+	if err := numeric.Delete(key); err != nil {
+		return err
+	}
+	return r.meta.Delete(key)
+}
+
+// countryRanges retourne les plages texte indexées pour country et leur clé numérique (nil si la plage ne se parse pas).
+func (r *liveRanges) countryRanges(country string) ([]string, [][]byte) {
+	var ranges []string
+	var keys [][]byte
+
+	nested := r.texts.countries.Bucket([]byte(country))
+	if nested == nil || country == "" {
+		return nil, nil
+	}
+	nested.ForEach(func(k, v []byte) error {
+		ranges = append(ranges, string(k))
+		if len(v) == 0 {
+			keys = append(keys, nil)
+		} else {
+			keys = append(keys, append([]byte(nil), v...))
+		}
+		return nil
+	})
+
+	return ranges, keys
+}
+
+// provenance enregistre la plage rangeText (clé key) comme écrite par la transaction.
+func (r *liveRanges) provenance(rangeText string, key []byte) error {
+	return putProvenance(r.meta, nil, key, Provenance{
+		Range:      rangeText,
+		Source:     UpsertSource,
+		RunID:      r.runID,
+		ImportedAt: r.now,
+	})
+}
+
+// upsert associe la plage rangeText (clé key) à country dans tous les buckets et index.
+// Les plages du même pays qui la chevauchent sont fusionnées: si l'une d'elles la contient, rien n'est écrit
+// (retourne false), sinon elles sont réduites à leur partie hors de key. Avec replace, les plages des autres
+// pays sont réduites de même (la plage écrite l'emporte); sans replace, elles sont laissées en place.
+func (r *liveRanges) upsert(rangeText string, key []byte, country string, replace bool) (bool, error) {
+	numeric := r.numericFor(key)
+	for _, o := range overlappingRanges(numeric, key) {
+		switch {
+		case bytes.Equal(o.key, key):
+			// Same bounds: plain re-assignment
+		case o.country == country && rangeContains(o.key, key):
+			return false, nil
+		case o.country == country || replace:
+			if _, err := r.trim(o.key, o.country, key); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if err := r.texts.put(rangeText, key, country); err != nil {
+		return false, err
+	}
+
+	r.spans.mark(numeric, key)
+	r.changed = true
+	if err := numeric.Put(key, []byte(country)); err != nil {
+		return false, err
+	}
+	return true, r.provenance(rangeText, key)
+}
+
+// trim réduit la plage stockée key (pays country) à ses parties hors de cut: la plage est retirée de tous
+// les buckets puis chaque partie est écrite sous sa forme canonique, avec la provenance de la plage d'origine.
+// Une partie dont les bornes sont déjà celles d'une plage d'un autre pays (bucket non encore disjoint: Writer
+// avant validation, flattenRanges) est un chevauchement: ErrOverlap, rien n'est modifié, sauf avec dropTaken
+// où la partie, déjà couverte, n'est pas écrite. Retourne les parties écrites.
+func (r *liveRanges) trim(key []byte, country string, cut []byte) ([][]byte, error) {
+	numeric := r.numericFor(key)
+	var parts [][]byte
+	for _, part := range subtractRange(key, cut) {
+		if taken := numeric.Get(part); taken != nil && string(taken) != country {
+			if r.dropTaken {
+				continue
+			}
+			return nil, fmt.Errorf("%w: %s (%s) overlaps %s (%s)",
+				ErrOverlap, canonicalRange(key), country, canonicalRange(part), taken)
+		}
+		parts = append(parts, part)
+	}
+
+	p, err := decodeProvenance(r.meta.Get(key))
+	hasProvenance := err == nil
+	r.changed = true
+
+	// Stored text ranges are canonical; older spellings are found through the country index
+	if rangeText := canonicalRange(key); string(r.texts.text.Get([]byte(rangeText))) == country {
+		if err := r.texts.delete(rangeText); err != nil {
+			return nil, err
+		}
+	} else if _, err := r.texts.deleteKey(key, country); err != nil {
+		return nil, err
+	}
+	if err := r.removeKey(key); err != nil {
+		return nil, err
+	}
+
+	for _, part := range parts {
+		rangeText := canonicalRange(part)
+		if err := r.texts.put(rangeText, part, country); err != nil {
+			return nil, err
+		}
+
+		r.spans.mark(numeric, part)
+		if err := numeric.Put(part, []byte(country)); err != nil {
+			return nil, err
+		}
+
+		if hasProvenance {
+			p.Range = rangeText
+			if err := r.meta.Put(part, encodeProvenance(p)); err != nil {
+				return nil, err
+			}
+		}
+		if r.onTrim != nil {
+			r.onTrim(part)
+		}
+	}
+
+	return parts, nil
+}
+
+// deleteRange supprime la plage rangeText, les autres formes texte de même clé key, la plage numérique
+// et sa provenance. Retourne le nombre de plages texte supprimées.
+func (r *liveRanges) deleteRange(rangeText string, key []byte) (int, error) {
+	// Other text forms are found through the country of either representation
+	countries := []string{string(r.texts.text.Get([]byte(rangeText)))}
+	if country := r.numericFor(key).Get(key); country != nil {
+		countries = append(countries, string(country))
+	}
+
+	count := 0
+	if r.texts.text.Get([]byte(rangeText)) != nil {
+		if err := r.texts.delete(rangeText); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	for _, country := range countries {
+		n, err := r.texts.deleteKey(key, country)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	if count > 0 {
+		r.changed = true
+	}
+
+	return count, r.removeKey(key)
+}
+
+// deleteCountry supprime toutes les plages texte de country et les plages numériques encore associées
+// à country. Retourne le nombre de plages texte supprimées.
+func (r *liveRanges) deleteCountry(country string) (int, error) {
+	ranges, keys := r.countryRanges(country)
+	r.changed = r.changed || len(ranges) > 0
+	for i, rangeText := range ranges {
+		if err := r.texts.delete(rangeText); err != nil {
+			return 0, err
+		}
+		if keys[i] == nil || string(r.numericFor(keys[i]).Get(keys[i])) != country {
+			continue
+		}
+		if err := r.removeKey(keys[i]); err != nil {
+			return 0, err
+		}
+	}
+	return len(ranges), nil
+}
+
+// reassignCountry associe à to toutes les plages de from. Retourne le nombre de plages texte réaffectées.
+func (r *liveRanges) reassignCountry(from, to string) (int, error) {
+	ranges, keys := r.countryRanges(from)
+	r.changed = r.changed || len(ranges) > 0
+	for i, rangeText := range ranges {
+		if err := r.texts.put(rangeText, keys[i], to); err != nil {
+			return 0, err
+		}
+		if keys[i] == nil {
+			continue
+		}
+
+		numeric := r.numericFor(keys[i])
+		if string(numeric.Get(keys[i])) != from {
+			continue
+		}
+		if err := numeric.Put(keys[i], []byte(to)); err != nil {
+			return 0, err
+		}
+		if err := r.provenance(rangeText, keys[i]); err != nil {
+			return 0, err
+		}
+	}
+	return len(ranges), nil
+}
+
+// finish recalcule les entrées de l'index des /16 touchées.
+func (r *liveRanges) finish() error {
+	return r.spans.refresh(r.index, r.numeric)
+}
+
+// mutate exécute fn dans une transaction d'écriture sur les buckets live et notifie les abonnés
+// si fn a modifié au moins un bucket (une plage numérique sans forme texte comprise).
+func (m *DBManager) mutate(fn func(r *liveRanges) (int, error)) (int, error) {
+	if err := m.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	changed := false
+	err := m.DB.Update(func(tx *bbolt.Tx) error {
+		r, err := openLiveRanges(tx)
+		if err != nil {
+			return err
+		}
+		if count, err = fn(r); err != nil {
+			return err
+		}
+		changed = r.changed
+		return r.finish()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if changed {
+		m.notifyChange()
+	}
+	return count, nil
+}
+
+// deleteIPRange supprime une plage ("start-end" ou CIDR, IPv4 ou IPv6): l'entrée texte, toutes les autres
+// formes texte de mêmes bornes, la plage numérique, sa provenance et ses entrées d'index.
+// Retourne le nombre de plages texte supprimées (0 si la plage n'existe pas), *ParseError si la plage est invalide.
+func (m *DBManager) deleteIPRange(ipRange string) (int, error) {
+	key, err := parseRangeKey(ipRange)
+	if err != nil {
+		return 0, &ParseError{Text: ipRange, Err: err}
+	}
+
+	count, err := m.mutate(func(r *liveRanges) (int, error) {
+		return r.deleteRange(canonicalRange(key), key)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error deleting range %s: %w", ipRange, err)
+	}
+
+	m.logger.Info("range deleted", "path", m.DBPath, "range", ipRange, "count", count)
+	return count, nil
+}
+
+// deleteCountry supprime toutes les plages d'un pays (texte, numériques, provenance, index).
+// Une plage numérique associée entre-temps à un autre pays est conservée.
+// Retourne le nombre de plages texte supprimées.
+func (m *DBManager) deleteCountry(country string) (int, error) {
+	if country == "" {
+		return 0, errors.New("empty country code")
+	}
+
+	count, err := m.mutate(func(r *liveRanges) (int, error) {
+		return r.deleteCountry(country)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error deleting country %s: %w", country, err)
+	}
+
+	m.logger.Info("country deleted", "path", m.DBPath, "country", country, "count", count)
+	return count, nil
+}
+
+// reassignCountry associe à to (code ISO 3166-1 alpha-2) toutes les plages de from (texte, numériques,
+// index par pays); la provenance des plages réaffectées devient UpsertSource. Les bornes ne changent pas:
+// l'index des /16 reste valide. Retourne le nombre de plages texte réaffectées.
+func (m *DBManager) reassignCountry(from, to string) (int, error) {
+	if from == "" {
+		return 0, errors.New("empty country code")
+	}
+	if !validCountryCode(to) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidCountry, to)
+	}
+	to = strings.ToUpper(to)
+	if from == to {
+		return 0, nil
+	}
+
+	count, err := m.mutate(func(r *liveRanges) (int, error) {
+		return r.reassignCountry(from, to)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error reassigning country %s to %s: %w", from, to, err)
+	}
+
+	m.logger.Info("country reassigned", "path", m.DBPath, "from", from, "to", to, "count", count)
+	return count, nil
+}
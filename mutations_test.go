@@ -0,0 +1,200 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestDeleteAndReassign(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24", "1.0.1.0-1.0.1.255", "2001:db8::/32"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := createTestZoneFile(tempDir, "DE", []string{"2.0.0.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := manager.importZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	locator, err := newMemoryIPLocator(manager, 100)
+	if err != nil {
+		t.Fatalf("Error creating memory locator: %v", err)
+	}
+	for _, ip := range []string{"1.0.0.1", "1.0.1.1", "2.0.0.1"} {
+		locator.lookupCountryByIP(ip)
+	}
+
+	consistent := func(step string) {
+		t.Helper()
+		report, err := manager.checkIndex(false)
+		if err != nil || !report.Consistent() {
+			t.Errorf("%s: inconsistent index: %+v (err: %v)", step, report, err)
+		}
+	}
+	expect := func(step string, expected map[string]string) {
+		t.Helper()
+		for ip, country := range expected {
+			got, err := locator.lookupCountryByIP(ip)
+			if got != country || (country == "") != errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: incorrect result for %s. Expected: %q, Got: %q (err: %v)", step, ip, country, got, err)
+			}
+		}
+	}
+
+	// Any spelling of the bounds deletes the canonical entry
+	count, err := manager.deleteIPRange("1.0.1.0 - 1.0.1.255")
+	if err != nil || count != 1 {
+		t.Errorf("DeleteRange: expected 1 text range, got %d (err: %v)", count, err)
+	}
+	if _, err := manager.rangeProvenance("1.0.1.0/24"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Provenance not deleted: %v", err)
+	}
+	consistent("DeleteRange")
+	expect("DeleteRange", map[string]string{"1.0.0.1": "FR", "1.0.1.1": ""})
+
+	if count, err := manager.deleteIPRange("9.0.0.0/24"); err != nil || count != 0 {
+		t.Errorf("Deleting an unknown range: %d (err: %v)", count, err)
+	}
+	var parseErr *ParseError
+	if _, err := manager.deleteIPRange("bogus"); !errors.As(err, &parseErr) {
+		t.Errorf("Expected a *ParseError, got %v", err)
+	}
+
+	count, err = manager.reassignCountry("FR", "BE")
+	if err != nil || count != 2 {
+		t.Errorf("ReassignCountry: expected 2 ranges, got %d (err: %v)", count, err)
+	}
+	consistent("ReassignCountry")
+	expect("ReassignCountry", map[string]string{"1.0.0.1": "BE", "2001:db8::1": "BE", "2.0.0.1": "DE"})
+	if ranges, _ := newIPLocator(manager, 0).listIPRangesByCountry("BE"); !reflect.DeepEqual(ranges, []string{"1.0.0.0/24", "2001:db8::/32"}) {
+		t.Errorf("Incorrect ranges after reassignment: %v", ranges)
+	}
+	if p, err := manager.rangeProvenance("1.0.0.0/24"); err != nil || p.Source != UpsertSource {
+		t.Errorf("Incorrect provenance after reassignment: %+v (err: %v)", p, err)
+	}
+
+	count, err = manager.deleteCountry("BE")
+	if err != nil || count != 2 {
+		t.Errorf("DeleteCountry: expected 2 ranges, got %d (err: %v)", count, err)
+	}
+	consistent("DeleteCountry")
+	expect("DeleteCountry", map[string]string{"1.0.0.1": "", "2001:db8::1": "", "2.0.0.1": "DE"})
+
+	if _, err := manager.deleteCountry(""); err == nil {
+		t.Error("An empty country code should be rejected")
+	}
+}
+
+func TestMutationsResetCache(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24", "1.0.1.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := manager.importZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	// Disk-backed locator: every answer comes from the cache once resolved
+	locator := newIPLocator(manager, 100)
+	lookup := func(step, ip, country string) {
+		t.Helper()
+		addr := netip.MustParseAddr(ip)
+		for i := 0; i < 2; i++ {
+			match, source, err := locator.lookupMatch(addr)
+			if match.country != country || (country == "") != errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: incorrect result for %s. Expected: %q, Got: %q (err: %v)", step, ip, country, match.country, err)
+			}
+			if i == 1 && country != "" && source != SourceCache {
+				t.Errorf("%s: second lookup of %s not served by the cache: %v", step, ip, source)
+			}
+		}
+	}
+
+	lookup("Before", "1.0.0.1", "FR")
+	lookup("Before", "1.0.1.1", "FR")
+
+	if count, err := manager.deleteIPRange("1.0.0.0/24"); err != nil || count != 1 {
+		t.Fatalf("DeleteRange: expected 1 range, got %d (err: %v)", count, err)
+	}
+	lookup("DeleteRange", "1.0.0.1", "")
+	lookup("DeleteRange", "1.0.1.1", "FR")
+
+	if count, err := manager.reassignCountry("FR", "BE"); err != nil || count != 1 {
+		t.Fatalf("ReassignCountry: expected 1 range, got %d (err: %v)", count, err)
+	}
+	lookup("ReassignCountry", "1.0.1.1", "BE")
+}
+
+func TestDeleteNumericOnlyRange(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// A numeric range without text form, written by an older version
+	err := manager.DB.Update(func(tx *bbolt.Tx) error {
+		key, _ := parseRangeKey("1.0.0.0/24")
+		if err := tx.Bucket([]byte("ip_ranges_numeric")).Put(key, []byte("FR")); err != nil {
+			return err
+		}
+		return rebuildPrefixIndex(tx, "ip_ranges_numeric", "ip_prefix_index")
+	})
+	if err != nil {
+		t.Fatalf("Error writing numeric range: %v", err)
+	}
+
+	locator := newIPLocator(manager, 100)
+	memory, err := newMemoryIPLocator(manager, 100)
+	if err != nil {
+		t.Fatalf("Error creating memory locator: %v", err)
+	}
+	for _, l := range []*IPLocator{locator, memory} {
+		if country, err := l.lookupCountryByIP("1.0.0.1"); err != nil || country != "FR" {
+			t.Fatalf("Incorrect country for 1.0.0.1. Expected: FR, Got: %q (err: %v)", country, err)
+		}
+	}
+
+	// No text range is deleted, but the locators must stop serving the range
+	if count, err := manager.deleteIPRange("1.0.0.0/24"); err != nil || count != 0 {
+		t.Errorf("DeleteRange: expected 0 text ranges, got %d (err: %v)", count, err)
+	}
+	for _, l := range []*IPLocator{locator, memory} {
+		if country, err := l.lookupCountryByIP("1.0.0.1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Deleted range still served: %q (err: %v)", country, err)
+		}
+	}
+}
+
+func TestDeleteReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	manager, err := openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "FR")
+	manager.closeDatabase()
+
+	manager, err = openDatabase(dbPath, true)
+	if err != nil {
+		t.Fatalf("Error opening database read-only: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	if _, err := manager.deleteIPRange("1.0.0.0/24"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeleteRange: expected ErrReadOnly, got %v", err)
+	}
+	if _, err := manager.deleteCountry("FR"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeleteCountry: expected ErrReadOnly, got %v", err)
+	}
+	if _, err := manager.reassignCountry("FR", "BE"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("ReassignCountry: expected ErrReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package ipcountrylocator
+
+import (
+	"os"
+	"time"
+)
+
+// Logger reçoit les diagnostics émis par la bibliothèque, avec des attributs structurés
+// en paires clé/valeur (file, line, bucket, ...). *slog.Logger satisfait directement cette interface.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// discardLogger ignore tous les messages (logger par défaut: aucune sortie console).
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, ...any) {}
+func (discardLogger) Info(string, ...any)  {}
+func (discardLogger) Warn(string, ...any)  {}
+func (discardLogger) Error(string, ...any) {}
+
+// PrivateRangePolicy détermine le traitement des réseaux privés / loopback / link-local lors d'un import.
+type PrivateRangePolicy int
+
+const (
+	// PrivateRangesSkip ignore ces réseaux (compteur FileReport.SkippedPrivate, défaut).
+	PrivateRangesSkip PrivateRangePolicy = iota
+	// PrivateRangesKeep les importe comme n'importe quelle plage publique.
+	PrivateRangesKeep
+)
+
+// dbOptions regroupe les réglages d'ouverture d'un DBManager.
+type dbOptions struct {
+	lockTimeout     time.Duration
+	fileMode        os.FileMode
+	noSync          bool
+	pageSize        int
+	initialMmapSize int
+	conflictPolicy  ConflictPolicy
+	privateRanges   PrivateRangePolicy
+	logger          Logger
+	migrationDryRun bool
+}
+
+// DBOption modifie un réglage d'OpenDatabase.
+type DBOption func(*dbOptions)
+
+// WithLockTimeout fixe l'attente maximale du verrou fichier BoltDB (défaut: 1s, 0 = attente infinie).
+func WithLockTimeout(timeout time.Duration) DBOption {
+	return func(o *dbOptions) {
+		o.lockTimeout = timeout
+	}
+}
+
+// WithFileMode fixe les permissions du fichier .db lors de sa création (défaut: 0600).
+func WithFileMode(mode os.FileMode) DBOption {
+	return func(o *dbOptions) {
+		o.fileMode = mode
+	}
+}
+
+// WithNoSync désactive le fsync après chaque commit (défaut: false).
+// Accélère les imports massifs au prix de la durabilité en cas de crash système.
+func WithNoSync(noSync bool) DBOption {
+	return func(o *dbOptions) {
+		o.noSync = noSync
+	}
+}
+
+// WithPageSize fixe la taille de page d'une nouvelle base (défaut: 4096; ignorée pour une base existante).
+func WithPageSize(size int) DBOption {
+	return func(o *dbOptions) {
+		o.pageSize = size
+	}
+}
+
+// WithInitialMmapSize fixe la taille initiale du mmap en octets (défaut: 0, taille du fichier).
+// Une valeur supérieure à la taille attendue évite les remappages pendant les imports,
+// remappages qui bloquent les transactions de lecture.
+func WithInitialMmapSize(size int) DBOption {
+	return func(o *dbOptions) {
+		o.initialMmapSize = size
+	}
+}
+
+// WithConflictPolicy fixe la politique de chevauchement entre pays (défaut: ConflictLastFileWins).
+// Équivalent à l'affectation de DBManager.ConflictPolicy après ouverture.
+func WithConflictPolicy(policy ConflictPolicy) DBOption {
+	return func(o *dbOptions) {
+		o.conflictPolicy = policy
+	}
+}
+
+// WithPrivateRangePolicy fixe le traitement des réseaux privés lors des imports (défaut: PrivateRangesSkip).
+// Équivalent à l'affectation de DBManager.PrivateRangePolicy après ouverture.
+func WithPrivateRangePolicy(policy PrivateRangePolicy) DBOption {
+	return func(o *dbOptions) {
+		o.privateRanges = policy
+	}
+}
+
+// WithLogger route tous les diagnostics du DBManager (et des localisateurs associés) vers logger.
+// Un logger nil rétablit le comportement par défaut (aucune sortie).
+func WithLogger(logger Logger) DBOption {
+	return func(o *dbOptions) {
+		o.logger = logger
+	}
+}
+
+// WithMigrationDryRun simule les migrations de schéma à l'ouverture: elles sont exécutées puis annulées
+// et décrites par DBManager.Migration(). Tant que des migrations restent en attente, les écritures
+// échouent avec ErrSchemaOutdated.
+func WithMigrationDryRun() DBOption {
+	return func(o *dbOptions) {
+		o.migrationDryRun = true
+	}
+}
+
+// newDBOptions applique les options sur les valeurs par défaut.
+func newDBOptions(opts []DBOption) dbOptions {
+	options := dbOptions{
+		lockTimeout: 1 * time.Second,
+		fileMode:    0600,
+		pageSize:    4096,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.logger == nil {
+		options.logger = discardLogger{}
+	}
+	return options
+}
+
+// locatorOptions regroupe les réglages d'un IPLocator.
+type locatorOptions struct {
+	noCache      bool
+	cacheTTL     time.Duration
+	negativeSize int
+	negativeTTL  time.Duration
+	memoryTable  bool
+	textFallback bool
+}
+
+// LocatorOption modifie un réglage de NewLocator.
+type LocatorOption func(*locatorOptions)
+
+// WithoutCache désactive le cache mémoire des résultats (équivalent à cacheSize <= 0).
+func WithoutCache() LocatorOption {
+	return func(o *locatorOptions) {
+		o.noCache = true
+	}
+}
+
+// WithCacheTTL fait expirer les entrées du cache après ttl (défaut: 0, pas d'expiration).
+// Utile lorsque la base est rafraîchie par un autre processus, sans notification au localisateur.
+func WithCacheTTL(ttl time.Duration) LocatorOption {
+	return func(o *locatorOptions) {
+		o.cacheTTL = ttl
+	}
+}
+
+// WithNegativeCache met en cache les adresses sans plage correspondante (défaut: désactivé),
+// dans un cache distinct de size entrées dont les entrées expirent après ttl (0 = sans expiration).
+// Les lookups répétés d'adresses non allouées ne touchent alors plus BoltDB; le cache négatif est vidé
+// après chaque import / upsert du DBManager.
+func WithNegativeCache(size int, ttl time.Duration) LocatorOption {
+	return func(o *locatorOptions) {
+		o.negativeSize = size
+		o.negativeTTL = ttl
+	}
+}
+
+// WithMemoryTable active le mode mémoire (voir NewMemoryLocator).
+// Si le chargement initial échoue, l'erreur est journalisée et le localisateur reste adossé à BoltDB.
+func WithMemoryTable() LocatorOption {
+	return func(o *locatorOptions) {
+		o.memoryTable = true
+	}
+}
+
+// WithTextFallback réactive le scan du bucket texte après un échec de l'index numérique (défaut: désactivé).
+// Ce scan est en O(N) à chaque adresse inconnue; préférer DBManager.CheckIndex pour détecter (et réparer)
+// les plages texte absentes de l'index numérique.
+func WithTextFallback() LocatorOption {
+	return func(o *locatorOptions) {
+		o.textFallback = true
+	}
+}
+
+// newLocatorOptions applique les options sur les valeurs par défaut.
+func newLocatorOptions(opts []LocatorOption) locatorOptions {
+	options := locatorOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
@@ -0,0 +1,254 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// *slog.Logger must satisfy Logger
+var _ Logger = (*slog.Logger)(nil)
+
+// recordedEntry est un message capturé par recordingLogger.
+type recordedEntry struct {
+	level string
+	msg   string
+	attrs map[string]any
+}
+
+// recordingLogger capture les messages pour inspection dans les tests.
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []recordedEntry
+}
+
+func (l *recordingLogger) record(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attrs := make(map[string]any)
+	for i := 0; i+1 < len(args); i += 2 {
+		attrs[args[i].(string)] = args[i+1]
+	}
+	l.entries = append(l.entries, recordedEntry{level: level, msg: msg, attrs: attrs})
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.record("ERROR", msg, args) }
+
+// find retourne le premier message de niveau et texte donnés.
+func (l *recordingLogger) find(level, msg string) (recordedEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range l.entries {
+		if e.level == level && e.msg == msg {
+			return e, true
+		}
+	}
+	return recordedEntry{}, false
+}
+
+func TestLoggerReceivesDiagnostics(t *testing.T) {
+	tempDir := t.TempDir()
+	logger := &recordingLogger{}
+
+	manager, err := openDatabase(filepath.Join(tempDir, "test.db"), false, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	filePath, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24", "10.0.0.0/8", "bogus"})
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if _, err := manager.importZoneFile(filePath); err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+
+	entry, found := logger.find("WARN", "invalid zone line")
+	if !found {
+		t.Fatal("The invalid line was not logged")
+	}
+	if entry.attrs["file"] != filePath || entry.attrs["line"] != 3 || entry.attrs["text"] != "bogus" {
+		t.Errorf("Incorrect attributes for invalid line: %v", entry.attrs)
+	}
+
+	if entry, found = logger.find("DEBUG", "private range skipped"); !found || entry.attrs["line"] != 2 {
+		t.Errorf("The private range was not logged at debug level: %v", entry.attrs)
+	}
+
+	if entry, found = logger.find("INFO", "zone file imported"); !found || entry.attrs["inserted"] != 1 {
+		t.Errorf("The import summary was not logged: %v", entry.attrs)
+	}
+
+	// A failing file is logged at error level
+	if _, err := manager.importZoneFile(filepath.Join(tempDir, "missing.zone")); err == nil {
+		t.Error("Importing a missing file should fail")
+	}
+	if _, found = logger.find("ERROR", "zone file import failed"); !found {
+		t.Error("The failed import was not logged")
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	tempDir := t.TempDir()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	manager, err := openDatabase(filepath.Join(tempDir, "test.db"), false, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	filePath, err := createTestZoneFile(tempDir, "FR", []string{"bogus"})
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if _, err := manager.importZoneFile(filePath); err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected a single warning line, got: %q", buf.String())
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Invalid JSON log line: %v", err)
+	}
+	if record["level"] != "WARN" || record["file"] != filePath || record["line"] != float64(1) {
+		t.Errorf("Incorrect JSON log record: %v", record)
+	}
+}
+
+func TestDefaultLoggerIsSilent(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Capture stdout while importing an invalid file
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	os.Stdout = w
+
+	filePath, _ := createTestZoneFile(tempDir, "FR", []string{"bogus"})
+	manager.importZoneFile(filePath)
+	manager.importZoneFile(filepath.Join(tempDir, "missing.zone"))
+
+	w.Close()
+	os.Stdout = stdout
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+	if buf.Len() != 0 {
+		t.Errorf("The library wrote to stdout: %q", buf.String())
+	}
+}
+
+func TestDBOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "test.db")
+
+	manager, err := openDatabase(dbPath, false,
+		WithFileMode(0640),
+		WithNoSync(true),
+		WithInitialMmapSize(1<<20),
+		WithConflictPolicy(ConflictReject),
+		WithPrivateRangePolicy(PrivateRangesKeep),
+	)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to stat database: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&^0640 != 0 {
+		t.Errorf("Incorrect file mode: %v", perm)
+	}
+	if !manager.DB.NoSync {
+		t.Error("NoSync was not applied")
+	}
+	if manager.ConflictPolicy != ConflictReject {
+		t.Errorf("Incorrect conflict policy: %v", manager.ConflictPolicy)
+	}
+
+	// Private ranges are kept
+	filePath, err := createTestZoneFile(tempDir, "FR", []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	report, err := manager.importZoneFile(filePath)
+	if err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+	if totals := report.Totals(); totals.Inserted != 1 || totals.SkippedPrivate != 0 {
+		t.Errorf("The private range was not imported: %+v", totals)
+	}
+
+	// The lock is held: a second open gives up after the timeout
+	start := time.Now()
+	if _, err := openDatabase(dbPath, false, WithLockTimeout(50*time.Millisecond)); err == nil {
+		t.Error("Opening a locked database should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("The lock timeout was not applied: %v", elapsed)
+	}
+}
+
+func TestLocatorOptions(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	filePath, _ := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24"})
+	if _, err := manager.importZoneFile(filePath); err != nil {
+		t.Fatalf("Error processing file: %v", err)
+	}
+
+	locator := newIPLocator(manager, 10, WithoutCache())
+	if country, err := locator.lookupCountryByIP("1.0.0.1"); err != nil || country != "FR" {
+		t.Errorf("Incorrect lookup: %s, %v", country, err)
+	}
+	if _, found := locator.Cache.getCountry(netip.MustParseAddr("1.0.0.1")); found {
+		t.Error("The result was cached despite WithoutCache")
+	}
+
+	locator = newIPLocator(manager, 10, WithCacheTTL(time.Minute))
+	if locator.Cache.ttl != time.Minute {
+		t.Errorf("Incorrect cache TTL: %v", locator.Cache.ttl)
+	}
+
+	if locator.textFallback {
+		t.Error("The text fallback should be disabled by default")
+	}
+	if locator = newIPLocator(manager, 10, WithTextFallback()); !locator.textFallback {
+		t.Error("WithTextFallback was not applied")
+	}
+
+	locator = newIPLocator(manager, 10, WithMemoryTable())
+	if locator.table.Load() == nil {
+		t.Fatal("The in-memory table was not loaded")
+	}
+	if country, err := locator.lookupCountryByIP("1.0.0.1"); err != nil || country != "FR" {
+		t.Errorf("Incorrect lookup: %s, %v", country, err)
+	}
+}
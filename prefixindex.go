@@ -0,0 +1,196 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+// Le bucket ip_prefix_index associe chaque /16 IPv4 (clé 2 octets) aux clés start|end (8 octets,
+// concaténées et triées) des plages candidates du bucket numérique: celles qui commencent dans le /16
+// et la plage précédente si elle le recouvre. Une recherche devient un Get suivi d'une dichotomie
+// dans la valeur, avec le même résultat que seekRange (plage de plus grand start <= adresse): comme lui,
+// l'index repose sur des plages disjointes dans le bucket numérique.
+// Un /16 sans entrée est résolu par seekRange.
+
+// prefixIndexBits est la longueur des préfixes indexés.
+const prefixIndexBits = 16
+
+// prefixOf retourne le /16 contenant une adresse IPv4 (4 octets big-endian).
+func prefixOf(addr []byte) uint16 {
+	return binary.BigEndian.Uint16(addr[:2])
+}
+
+// lookupPrefixIndex résout addr (IPv4) à l'aide de l'index.
+// Retourne la clé et la valeur de la plage trouvée (nil si aucune) et ok = false si l'index ne couvre
+// pas le /16 ou référence une plage absente du bucket numérique: l'appelant utilise alors seekRange.
+func lookupPrefixIndex(index, numeric *bbolt.Bucket, addr []byte) ([]byte, []byte, bool) {
+	candidates := index.Get(addr[:2])
+	if len(candidates) == 0 || len(candidates)%8 != 0 {
+		return nil, nil, false
+	}
+
+	// Last candidate starting at or before addr
+	n := len(candidates) / 8
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(candidates[i*8:i*8+4], addr) > 0
+	}) - 1
+	if i < 0 {
+		return nil, nil, true
+	}
+
+	k := candidates[i*8 : i*8+8]
+	if bytes.Compare(addr, k[4:]) > 0 {
+		return nil, nil, true
+	}
+
+	v := numeric.Get(k)
+	if v == nil {
+		return nil, nil, false
+	}
+	return k, v, true
+}
+
+// prefixIndexCandidates calcule la valeur d'index du /16 prefix à partir du bucket numérique.
+func prefixIndexCandidates(numeric *bbolt.Bucket, prefix uint16) []byte {
+	var base [8]byte
+	binary.BigEndian.PutUint16(base[:2], prefix)
+
+	var candidates []byte
+	c := numeric.Cursor()
+	k, _ := c.Seek(base[:])
+
+	// The predecessor answers for the addresses before the first range of the /16
+	var pk []byte
+	if k == nil {
+		pk, _ = c.Last()
+	} else {
+		pk, _ = c.Prev()
+		k, _ = c.Seek(base[:])
+	}
+	if len(pk) == 8 && bytes.Compare(pk[4:], base[:4]) >= 0 {
+		candidates = append(candidates, pk...)
+	}
+
+	for ; k != nil && len(k) == 8 && prefixOf(k) == prefix; k, _ = c.Next() {
+		candidates = append(candidates, k...)
+	}
+
+	return candidates
+}
+
+// refreshPrefixIndex recalcule les entrées d'index des /16 de from à to inclus.
+func refreshPrefixIndex(index, numeric *bbolt.Bucket, from, to uint16) error {
+	for p := uint32(from); p <= uint32(to); p++ {
+		var key [2]byte
+		binary.BigEndian.PutUint16(key[:], uint16(p))
+
+		candidates := prefixIndexCandidates(numeric, uint16(p))
+		if candidates == nil {
+			if err := index.Delete(key[:]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := index.Put(key[:], candidates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixSpan retourne les /16 dont l'entrée d'index dépend de la présence de la plage key:
+// ceux qu'elle recouvre et ceux que recouvre la plage qui la précède dans le bucket, dont elle
+// peut masquer (ou démasquer) le rôle de plage précédente.
+func prefixSpan(numeric *bbolt.Bucket, key []byte) (uint16, uint16) {
+	from, to := prefixOf(key[:4]), prefixOf(key[4:8])
+
+	c := numeric.Cursor()
+	k, _ := c.Seek(key)
+	if k != nil {
+		k, _ = c.Prev()
+	} else {
+		k, _ = c.Last()
+	}
+	if len(k) == 8 && prefixOf(k[4:]) > to {
+		to = prefixOf(k[4:])
+	}
+
+	return from, to
+}
+
+// prefixSpans accumule les /16 à recalculer après une série d'écritures dans un bucket numérique IPv4.
+type prefixSpans struct {
+	dirty map[uint16]struct{}
+}
+
+// mark enregistre les /16 dépendant de la plage key (à appeler avant et après sa modification
+// revient au même: la plage précédente dans le bucket ne change pas).
+func (s *prefixSpans) mark(numeric *bbolt.Bucket, key []byte) {
+	if len(key) != 8 {
+		return
+	}
+	if s.dirty == nil {
+		s.dirty = make(map[uint16]struct{})
+	}
+	from, to := prefixSpan(numeric, key)
+	for p := uint32(from); p <= uint32(to); p++ {
+		s.dirty[uint16(p)] = struct{}{}
+	}
+}
+
+// refresh recalcule les /16 marqués.
+func (s *prefixSpans) refresh(index, numeric *bbolt.Bucket) error {
+	for p := range s.dirty {
+		if err := refreshPrefixIndex(index, numeric, p, p); err != nil {
+			return err
+		}
+	}
+	s.dirty = nil
+	return nil
+}
+
+// rebuildPrefixIndex reconstruit entièrement l'index indexName à partir du bucket numérique numericName.
+func rebuildPrefixIndex(tx *bbolt.Tx, numericName, indexName string) error {
+	numeric := tx.Bucket([]byte(numericName))
+	if numeric == nil {
+		return bucketMissing(numericName)
+	}
+
+	if err := tx.DeleteBucket([]byte(indexName)); err != nil && err != bbolt.ErrBucketNotFound {
+		return fmt.Errorf("error deleting bucket %s: %v", indexName, err)
+	}
+	index, err := tx.CreateBucket([]byte(indexName))
+	if err != nil {
+		return fmt.Errorf("error creating bucket %s: %v", indexName, err)
+	}
+
+	// Every /16 touched by a range, in order
+	var touched [1 << prefixIndexBits]bool
+	err = numeric.ForEach(func(k, _ []byte) error {
+		if len(k) == 8 {
+			for p := uint32(prefixOf(k[:4])); p <= uint32(prefixOf(k[4:])); p++ {
+				touched[p] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keys are written in order: pack pages fully
+	index.FillPercent = 1.0
+	for p := range touched {
+		if touched[p] {
+			if err := refreshPrefixIndex(index, numeric, uint16(p), uint16(p)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
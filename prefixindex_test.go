@@ -0,0 +1,233 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// checkPrefixIndex vérifie que l'index maintenu incrémentalement est identique à une reconstruction
+// complète et que chaque adresse de probes est résolue comme par seekRange.
+func checkPrefixIndex(t *testing.T, manager *DBManager, probes []uint32) {
+	t.Helper()
+
+	snapshot := func(tx *bbolt.Tx) map[string]string {
+		entries := make(map[string]string)
+		tx.Bucket([]byte("ip_prefix_index")).ForEach(func(k, v []byte) error {
+			entries[string(k)] = string(v)
+			return nil
+		})
+		return entries
+	}
+
+	errRollback := errors.New("rollback")
+	manager.DB.Update(func(tx *bbolt.Tx) error {
+		maintained := snapshot(tx)
+		if err := rebuildPrefixIndex(tx, "ip_ranges_numeric", "ip_prefix_index"); err != nil {
+			t.Fatalf("Error rebuilding index: %v", err)
+		}
+		rebuilt := snapshot(tx)
+
+		if len(maintained) != len(rebuilt) {
+			t.Errorf("Index size mismatch. Maintained: %d, Rebuilt: %d", len(maintained), len(rebuilt))
+		}
+		for k, v := range rebuilt {
+			if maintained[k] != v {
+				t.Errorf("Index entry %x mismatch. Maintained: %x, Rebuilt: %x", k, maintained[k], v)
+			}
+		}
+		return errRollback
+	})
+
+	manager.DB.View(func(tx *bbolt.Tx) error {
+		numeric := tx.Bucket([]byte("ip_ranges_numeric"))
+		index := tx.Bucket([]byte("ip_prefix_index"))
+		for _, ip := range probes {
+			var addr [4]byte
+			encodeUint32BE(addr[:], ip)
+
+			wantK, wantV := seekRange(numeric, addr[:])
+			k, v, ok := lookupPrefixIndex(index, numeric, addr[:])
+			if !ok {
+				k, v = seekRange(numeric, addr[:])
+			}
+			if !bytes.Equal(k, wantK) || !bytes.Equal(v, wantV) {
+				t.Errorf("%v: index gives %x=%s, seekRange gives %x=%s", addr, k, v, wantK, wantV)
+			}
+		}
+		return nil
+	})
+}
+
+func TestPrefixIndex(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	rng := rand.New(rand.NewSource(1))
+
+	// Ranges of various sizes in a narrow area, including same-country overlaps and
+	// ranges spanning several /16
+	var probes []uint32
+	for i := 0; i < 300; i++ {
+		start := 0x0B000000 + uint32(rng.Intn(1<<20))
+		size := uint32(1) << uint(rng.Intn(18))
+		end := start + size - 1
+		country := []string{"FR", "DE", "IT"}[rng.Intn(3)]
+
+		ipRange := formatRangeKey(ipv4RangeKey(start, end))
+		if _, err := manager.upsertIPRangeCountry(ipRange, start, end, country); err != nil {
+			t.Fatalf("Error adding IP range: %v", err)
+		}
+		probes = append(probes, start, end, end+1, start-1)
+	}
+
+	// A range nested in a larger one takes over its predecessor role for the following /16
+	for _, r := range []string{"12.0.0.0-12.3.255.255", "12.0.1.0/24"} {
+		start, end, _ := parseIPRange(r)
+		if _, err := manager.upsertIPRangeCountry(r, start, end, "FR"); err != nil {
+			t.Fatalf("Error adding IP range: %v", err)
+		}
+		probes = append(probes, start, end, end+1, 0x0C010001, 0x0C030001)
+	}
+
+	for i := 0; i < 2000; i++ {
+		probes = append(probes, 0x0B000000+uint32(rng.Intn(1<<21)))
+	}
+	checkPrefixIndex(t, manager, probes)
+
+	// Imports resolve conflicts by deleting ranges: the index follows
+	if _, err := createTestZoneFile(tempDir, "ES", []string{"11.0.0.0/14", "11.8.0.0-11.9.0.10"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := manager.importZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+	checkPrefixIndex(t, manager, probes)
+
+	// A synchronization swaps in a staging index
+	if _, err := manager.syncZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error synchronizing directory: %v", err)
+	}
+	checkPrefixIndex(t, manager, probes)
+
+	locator := newIPLocator(manager, 0)
+	for ip, expected := range map[string]string{"11.1.2.3": "ES", "11.9.0.10": "ES", "11.9.0.11": ""} {
+		country, err := locator.lookupCountryByIP(ip)
+		if country != expected || (expected == "") != errors.Is(err, ErrNotFound) {
+			t.Errorf("Incorrect result for %s. Expected: %q, Got: %q (err: %v)", ip, expected, country, err)
+		}
+	}
+}
+
+func TestPrefixIndexNested(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Nested ranges through upserts: same country (merged) and another country (carved out)
+	for _, u := range []struct{ r, country string }{
+		{"12.0.0.0-12.3.255.255", "FR"},
+		{"12.1.5.0/24", "FR"},
+		{"12.2.0.0/24", "DE"},
+	} {
+		start, end, _ := parseIPRange(u.r)
+		if _, err := manager.upsertIPRangeCountry(u.r, start, end, u.country); err != nil {
+			t.Fatalf("Error adding IP range: %v", err)
+		}
+	}
+
+	// Nested ranges through an import: within a file and across files
+	if _, err := createTestZoneFile(tempDir, "AA", []string{"13.0.0.0/14", "13.1.0.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := createTestZoneFile(tempDir, "DE", []string{"13.2.7.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := manager.importZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	expected := map[string]string{
+		"12.0.0.1": "FR", "12.1.5.1": "FR", "12.1.200.1": "FR", "12.2.0.1": "DE", "12.2.1.1": "FR",
+		"12.3.255.255": "FR", "12.4.0.0": "",
+		"13.0.0.1": "AA", "13.1.0.1": "AA", "13.1.1.1": "AA", "13.2.7.1": "DE", "13.2.8.1": "AA",
+		"13.3.0.1": "AA", "13.4.0.0": "",
+	}
+
+	var probes []uint32
+	manager.DB.View(func(tx *bbolt.Tx) error {
+		numeric := tx.Bucket([]byte("ip_ranges_numeric"))
+		index := tx.Bucket([]byte("ip_prefix_index"))
+		for ip, country := range expected {
+			start, _, _ := parseIPRange(ip + "/32")
+			probes = append(probes, start)
+
+			var addr [4]byte
+			encodeUint32BE(addr[:], start)
+			k, v, ok := lookupPrefixIndex(index, numeric, addr[:])
+			if ip == "12.4.0.0" || ip == "13.4.0.0" {
+				// Past the last range: the /16 is not indexed
+				continue
+			}
+			if !ok {
+				t.Errorf("%s: not resolved by the index", ip)
+			} else if string(v) != country || (country == "") != (k == nil) {
+				t.Errorf("%s: index gives %x=%q, expected %q", ip, k, v, country)
+			}
+		}
+		return nil
+	})
+	checkPrefixIndex(t, manager, probes)
+
+	locator := newIPLocator(manager, 0)
+	for ip, country := range expected {
+		got, err := locator.lookupCountryByIP(ip)
+		if got != country || (country == "") != errors.Is(err, ErrNotFound) {
+			t.Errorf("Incorrect result for %s. Expected: %q, Got: %q (err: %v)", ip, country, got, err)
+		}
+	}
+}
+
+func TestPrefixIndexStale(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start, end, _ := parseIPRange("1.0.0.0/24")
+	manager.upsertIPRangeCountry("1.0.0.0/24", start, end, "FR")
+
+	// An index entry pointing at a missing range falls back to the cursor search
+	err := manager.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("ip_prefix_index")).Put([]byte{1, 0}, ipv4RangeKey(start, start+10))
+	})
+	if err != nil {
+		t.Fatalf("Error corrupting index: %v", err)
+	}
+
+	locator := newIPLocator(manager, 0)
+	if country, err := locator.lookupCountryByIP("1.0.0.5"); err != nil || country != "FR" {
+		t.Errorf("Incorrect country for 1.0.0.5. Expected: FR, Got: %s (err: %v)", country, err)
+	}
+}
+
+func TestPrefixIndexMigration(t *testing.T) {
+	dbPath := createLegacyDB(t)
+
+	manager, err := openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error migrating database: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	err = manager.DB.View(func(tx *bbolt.Tx) error {
+		start, end, _ := parseIPRange("1.0.0.0/24")
+		if v := tx.Bucket([]byte("ip_prefix_index")).Get([]byte{1, 0}); !bytes.Equal(v, ipv4RangeKey(start, end)) {
+			t.Errorf("Index not built by the migration: %x", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
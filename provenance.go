@@ -0,0 +1,144 @@
+package ipcountrylocator
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// UpsertSource est la source enregistrée pour les plages écrites par UpsertRange / UpsertRangeV6 / UpsertPrefix.
+const UpsertSource = "upsert"
+
+// provenanceVersion est la version de l'encodage des valeurs du bucket ip_ranges_meta.
+const provenanceVersion = 1
+
+// Provenance décrit l'origine d'une plage stockée.
+// Source est le fichier .zone (ou UpsertSource), Line la ligne dans ce fichier (0 pour un upsert),
+// RunID l'identifiant de l'import ou de l'upsert ayant écrit la plage en dernier et ImportedAt sa date.
+// FirstImportedAt est conservée lors des ré-imports et upserts successifs de la même plage.
+// Range est la forme texte de la plage telle qu'elle a été écrite.
+type Provenance struct {
+	Range           string
+	Source          string
+	Line            int
+	RunID           string
+	ImportedAt      time.Time
+	FirstImportedAt time.Time
+}
+
+// newRunID génère un identifiant d'import aléatoire (16 caractères hexadécimaux).
+func newRunID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Fall back on the clock: uniqueness within a process is enough for reports
+		binary.BigEndian.PutUint64(b[:], uint64(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// encodeProvenance encode une provenance pour le bucket ip_ranges_meta:
+// version (1 octet), ImportedAt et FirstImportedAt (UnixNano, 8 octets big-endian chacun),
+// Line (uvarint) puis RunID, Source et Range (longueur uvarint + octets).
+func encodeProvenance(p Provenance) []byte {
+	buf := make([]byte, 0, 17+3*binary.MaxVarintLen16+len(p.RunID)+len(p.Source)+len(p.Range))
+	buf = append(buf, provenanceVersion)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(p.ImportedAt.UnixNano()))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(p.FirstImportedAt.UnixNano()))
+	buf = binary.AppendUvarint(buf, uint64(p.Line))
+	for _, s := range []string{p.RunID, p.Source, p.Range} {
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+// decodeProvenance décode une valeur du bucket ip_ranges_meta.
+func decodeProvenance(b []byte) (Provenance, error) {
+	if len(b) < 17 || b[0] != provenanceVersion {
+		return Provenance{}, errors.New("invalid provenance record")
+	}
+
+	p := Provenance{
+		ImportedAt:      time.Unix(0, int64(binary.BigEndian.Uint64(b[1:9]))),
+		FirstImportedAt: time.Unix(0, int64(binary.BigEndian.Uint64(b[9:17]))),
+	}
+	b = b[17:]
+
+	line, n := binary.Uvarint(b)
+	if n <= 0 {
+		return Provenance{}, errors.New("invalid provenance record")
+	}
+	p.Line = int(line)
+	b = b[n:]
+
+	for _, field := range []*string{&p.RunID, &p.Source, &p.Range} {
+		size, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < size {
+			return Provenance{}, errors.New("invalid provenance record")
+		}
+		*field = string(b[n : n+int(size)])
+		b = b[n+int(size):]
+	}
+
+	return p, nil
+}
+
+// putProvenance enregistre p pour la clé numérique key.
+// FirstImportedAt est reprise de l'enregistrement existant dans bucket, ou à défaut dans previous
+// (bucket live lors d'une synchronisation via staging, peut être nil).
+func putProvenance(bucket, previous *bbolt.Bucket, key []byte, p Provenance) error {
+	p.FirstImportedAt = p.ImportedAt
+
+	existing := bucket.Get(key)
+	if existing == nil && previous != nil {
+		existing = previous.Get(key)
+	}
+	if existing != nil {
+		if old, err := decodeProvenance(existing); err == nil && !old.FirstImportedAt.IsZero() {
+			p.FirstImportedAt = old.FirstImportedAt
+		}
+	}
+
+	return bucket.Put(key, encodeProvenance(p))
+}
+
+// lookupProvenance lit la provenance de la plage de clé numérique key (start|end).
+// Retourne false si la base ne la conserve pas (plage antérieure au suivi ou bucket absent).
+func lookupProvenance(tx *bbolt.Tx, key []byte) (Provenance, bool) {
+	bucket := tx.Bucket([]byte(liveBuckets.meta))
+	if bucket == nil {
+		return Provenance{}, false
+	}
+
+	p, err := decodeProvenance(bucket.Get(key))
+	return p, err == nil
+}
+
+// rangeProvenance retourne la provenance d'une plage ("start-end" ou CIDR, IPv4 ou IPv6).
+// Erreurs: *ParseError si la plage est invalide, ErrNotFound si aucune provenance n'est enregistrée.
+func (m *DBManager) rangeProvenance(ipRange string) (Provenance, error) {
+	key, err := parseRangeKey(ipRange)
+	if err != nil {
+		return Provenance{}, &ParseError{Text: ipRange, Err: err}
+	}
+
+	var p Provenance
+	found := false
+	err = m.DB.View(func(tx *bbolt.Tx) error {
+		p, found = lookupProvenance(tx, key)
+		return nil
+	})
+	if err != nil {
+		return Provenance{}, err
+	}
+	if !found {
+		return Provenance{}, fmt.Errorf("%w: no provenance for range %s", ErrNotFound, ipRange)
+	}
+
+	return p, nil
+}
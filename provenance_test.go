@@ -0,0 +1,164 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestProvenanceEncoding(t *testing.T) {
+	p := Provenance{
+		Range:           "1.0.0.0/24",
+		Source:          "/data/fr.zone",
+		Line:            1234,
+		RunID:           "0123456789abcdef",
+		ImportedAt:      time.Unix(0, 1700000000123456789),
+		FirstImportedAt: time.Unix(0, 1600000000000000000),
+	}
+
+	decoded, err := decodeProvenance(encodeProvenance(p))
+	if err != nil {
+		t.Fatalf("Error decoding provenance: %v", err)
+	}
+	if decoded.Range != p.Range || decoded.Source != p.Source || decoded.Line != p.Line || decoded.RunID != p.RunID ||
+		!decoded.ImportedAt.Equal(p.ImportedAt) || !decoded.FirstImportedAt.Equal(p.FirstImportedAt) {
+		t.Errorf("Incorrect round trip. Expected: %+v, Got: %+v", p, decoded)
+	}
+
+	// Truncated or unknown records are rejected
+	encoded := encodeProvenance(p)
+	for _, b := range [][]byte{nil, encoded[:10], encoded[:len(encoded)-1], append([]byte{9}, encoded[1:]...)} {
+		if _, err := decodeProvenance(b); err == nil {
+			t.Errorf("Decoding %x should fail", b)
+		}
+	}
+}
+
+func TestRangeProvenance(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	file, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24", "# comment", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	first, err := manager.importZoneFile(file)
+	if err != nil {
+		t.Fatalf("Error importing file: %v", err)
+	}
+	if first.RunID == "" {
+		t.Fatal("The import report should carry a run ID")
+	}
+
+	testCases := []struct {
+		ipRange string
+		line    int
+	}{
+		{"1.0.0.0/24", 1},
+		{"1.0.0.0-1.0.0.255", 1},
+		{"2001:db8::/32", 3},
+	}
+	for _, tc := range testCases {
+		p, err := manager.rangeProvenance(tc.ipRange)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.ipRange, err)
+			continue
+		}
+		if p.Source != file || p.Line != tc.line || p.RunID != first.RunID || p.ImportedAt.IsZero() {
+			t.Errorf("%s: incorrect provenance %+v", tc.ipRange, p)
+		}
+		if !p.FirstImportedAt.Equal(p.ImportedAt) {
+			t.Errorf("%s: first import date should match the import date, got %+v", tc.ipRange, p)
+		}
+	}
+
+	if _, err := manager.rangeProvenance("5.0.0.0/24"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown range, got %v", err)
+	}
+	var parseErr *ParseError
+	if _, err := manager.rangeProvenance("not-a-range"); !errors.As(err, &parseErr) {
+		t.Errorf("Expected *ParseError for an invalid range, got %v", err)
+	}
+
+	initial, _ := manager.rangeProvenance("1.0.0.0/24")
+
+	// A re-import records the new run and keeps the first import date
+	second, err := manager.importZoneFile(file)
+	if err != nil {
+		t.Fatalf("Error re-importing file: %v", err)
+	}
+	p, _ := manager.rangeProvenance("1.0.0.0/24")
+	if p.RunID != second.RunID || p.RunID == first.RunID || !p.FirstImportedAt.Equal(initial.FirstImportedAt) {
+		t.Errorf("Incorrect provenance after re-import: %+v", p)
+	}
+
+	// An upsert marks the range as manual and still keeps the first import date
+	if _, err := manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "IT"); err != nil {
+		t.Fatalf("Error updating range: %v", err)
+	}
+	p, _ = manager.rangeProvenance("1.0.0.0/24")
+	if p.Source != UpsertSource || p.Line != 0 || p.RunID == second.RunID || !p.FirstImportedAt.Equal(initial.FirstImportedAt) {
+		t.Errorf("Incorrect provenance after upsert: %+v", p)
+	}
+
+	// A synchronization goes through staging and keeps the first import date as well
+	sync, err := manager.syncZoneDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("Error synchronizing directory: %v", err)
+	}
+	p, _ = manager.rangeProvenance("1.0.0.0/24")
+	if p.Source != file || p.RunID != sync.RunID || !p.FirstImportedAt.Equal(initial.FirstImportedAt) {
+		t.Errorf("Incorrect provenance after sync: %+v", p)
+	}
+}
+
+func TestProvenanceConflicts(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := createTestZoneFile(tempDir, "DE", []string{"1.0.0.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/16"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	if _, err := manager.importZoneDirectory(tempDir); err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	// The losing range disappears with its provenance
+	if _, err := manager.rangeProvenance("1.0.0.0/24"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for the replaced range, got %v", err)
+	}
+	if p, err := manager.rangeProvenance("1.0.0.0/16"); err != nil || p.Range != "1.0.0.0/16" {
+		t.Errorf("Incorrect provenance for the winning range: %+v (err: %v)", p, err)
+	}
+}
+
+func TestLookupDetailedProvenance(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	file, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24"})
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	report, err := manager.importZoneFile(file)
+	if err != nil {
+		t.Fatalf("Error importing file: %v", err)
+	}
+
+	locator := newIPLocator(manager, 100)
+	for i := 0; i < 2; i++ {
+		result, err := locator.lookupDetailed("1.0.0.1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.SourceFile != file || result.SourceLine != 1 || result.RunID != report.RunID || result.ImportedAt.IsZero() {
+			t.Errorf("Incorrect provenance in result (%s): %+v", result.Source, result)
+		}
+	}
+}
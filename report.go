@@ -0,0 +1,125 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"time"
+)
+
+// ImportReport résume un import ou une synchronisation: compteurs par fichier, erreurs par ligne,
+// chevauchements entre pays et durée.
+type ImportReport struct {
+	Files     []FileReport
+	Errors    []LineError
+	Conflicts []RangeConflict
+	Rewrites  []RangeRewrite
+
+	// Removed compte les entrées texte supprimées (SyncDirectory uniquement).
+	Removed int
+
+	// RunID identifie l'import dans la provenance des plages écrites (voir RangeProvenance).
+	RunID string
+
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+// FileReport détaille le traitement d'un fichier .zone.
+// Parsed = SkippedPrivate + Invalid + Inserted + Changed + Unchanged + Rejected.
+// Inserted / Changed / Unchanged sont évalués par rapport aux données live (plage texte).
+// Err contient l'erreur ayant interrompu le fichier (ouverture, lecture, écriture), sinon nil.
+type FileReport struct {
+	File    string
+	Country string
+
+	Parsed         int // Lignes non vides et non commentées
+	SkippedPrivate int // Réseaux privés / loopback / link-local ignorés
+	Invalid        int // Lignes impossibles à parser (détail dans ImportReport.Errors)
+	Inserted       int // Nouvelles plages
+	Changed        int // Plages existantes ré-associées à ce pays
+	Unchanged      int // Plages déjà associées à ce pays
+	Rejected       int // Plages écartées par la politique de conflit (détail dans ImportReport.Conflicts)
+	Rewritten      int // Plages non canoniques, stockées sous leur forme canonique (détail dans ImportReport.Rewrites)
+
+	Duration time.Duration
+	Err      error
+}
+
+// LineError décrit une ligne rejetée: fichier, numéro de ligne, texte et raison.
+// Err porte la même information sous forme de *ParseError (errors.As).
+type LineError struct {
+	File   string
+	Line   int
+	Text   string
+	Reason string
+	Err    error
+}
+
+// RangeRewrite décrit une ligne dont la plage n'était pas sous forme canonique (CIDR non masqué, CIDR
+// écrit "start-end", espaces, IPv6 non compressée...): Text est la ligne lue, Canonical la clé texte stockée.
+type RangeRewrite struct {
+	File      string
+	Line      int
+	Text      string
+	Canonical string
+}
+
+// Totals agrège les compteurs de tous les fichiers (File, Country, Duration et Err restent vides).
+func (r *ImportReport) Totals() FileReport {
+	var total FileReport
+	for _, f := range r.Files {
+		total.Parsed += f.Parsed
+		total.SkippedPrivate += f.SkippedPrivate
+		total.Invalid += f.Invalid
+		total.Inserted += f.Inserted
+		total.Changed += f.Changed
+		total.Unchanged += f.Unchanged
+		total.Rejected += f.Rejected
+		total.Rewritten += f.Rewritten
+	}
+	return total
+}
+
+// HasErrors indique si au moins un fichier a échoué ou une ligne a été rejetée comme invalide.
+func (r *ImportReport) HasErrors() bool {
+	if len(r.Errors) > 0 {
+		return true
+	}
+	for _, f := range r.Files {
+		if f.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Err regroupe (errors.Join) les erreurs des fichiers en échec puis des lignes invalides, nil sinon.
+// Les lignes invalides s'extraient avec errors.As(err, &parseErr) où parseErr est un *ParseError.
+func (r *ImportReport) Err() error {
+	var errs []error
+	for _, f := range r.Files {
+		if f.Err != nil {
+			errs = append(errs, f.Err)
+		}
+	}
+	for _, e := range r.Errors {
+		if e.Err != nil {
+			errs = append(errs, e.Err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newImportReport démarre un rapport horodaté.
+func newImportReport() *ImportReport {
+	return &ImportReport{StartedAt: time.Now()}
+}
+
+// finish complète le rapport avec l'identifiant d'import, les conflits, erreurs et réécritures collectés, puis fixe la durée.
+func (r *ImportReport) finish(state *importState) *ImportReport {
+	r.RunID = state.runID
+	r.Conflicts = state.conflicts
+	r.Errors = state.errors
+	r.Rewrites = state.rewrites
+	r.Duration = time.Since(r.StartedAt)
+	return r
+}
@@ -0,0 +1,92 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportReport(t *testing.T) {
+	manager, tempDir, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := createTestZoneFile(tempDir, "FR", []string{
+		"# FR ranges",
+		"1.0.0.0/24",
+		"2.0.0.0/24",
+		"10.0.0.0/8", // Private
+		"",
+		"1.2.3.4",          // Invalid: single address
+		"3.0.0.10-3.0.0.1", // Invalid: inverted bounds
+	}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	report, err := manager.importZoneDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	if len(report.Files) != 1 {
+		t.Fatalf("Incorrect number of file reports. Expected: 1, Got: %d", len(report.Files))
+	}
+
+	fr := report.Files[0]
+	if fr.Country != "FR" || fr.Parsed != 5 || fr.SkippedPrivate != 1 || fr.Invalid != 2 || fr.Inserted != 2 {
+		t.Errorf("Incorrect FR report: %+v", fr)
+	}
+
+	if len(report.Errors) != 2 {
+		t.Fatalf("Incorrect number of line errors. Expected: 2, Got: %d", len(report.Errors))
+	}
+
+	lineErr := report.Errors[0]
+	if lineErr.File != filepath.Join(tempDir, "FR.zone") || lineErr.Line != 6 || lineErr.Text != "1.2.3.4" || lineErr.Reason == "" {
+		t.Errorf("Incorrect line error: %+v", lineErr)
+	}
+	var parseErr *ParseError
+	if lineErr := report.Errors[1]; lineErr.Line != 7 || !errors.As(lineErr.Err, &parseErr) || !errors.Is(lineErr.Err, ErrInvalidRange) {
+		t.Errorf("Incorrect line error for an inverted range: %+v", lineErr)
+	}
+
+	if !report.HasErrors() {
+		t.Error("A report with invalid lines should have errors")
+	}
+
+	if report.StartedAt.IsZero() || report.Duration <= 0 {
+		t.Errorf("Incorrect timing: started %v, duration %v", report.StartedAt, report.Duration)
+	}
+
+	// Second run: one range moves to DE, the other is unchanged, and an unreadable file is reported
+	if err := os.Remove(filepath.Join(tempDir, "FR.zone")); err != nil {
+		t.Fatalf("Failed to remove test file: %v", err)
+	}
+	if _, err := createTestZoneFile(tempDir, "FR", []string{"1.0.0.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if _, err := createTestZoneFile(tempDir, "IT", []string{"2.0.0.0/24"}); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "DE.zone"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	report, err = manager.importZoneDirectory(tempDir)
+	if err != nil {
+		t.Fatalf("Error processing directory: %v", err)
+	}
+
+	totals := report.Totals()
+	if totals.Unchanged != 1 || totals.Changed != 1 || totals.Inserted != 0 {
+		t.Errorf("Incorrect totals: %+v", totals)
+	}
+
+	if report.Files[0].Country != "DE" || report.Files[0].Err == nil {
+		t.Errorf("The unreadable file should be reported: %+v", report.Files[0])
+	}
+
+	if !report.HasErrors() {
+		t.Error("A report with a failed file should have errors")
+	}
+}
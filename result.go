@@ -0,0 +1,73 @@
+package ipcountrylocator
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Result détaille la résolution d'une adresse: pays, plage retenue et origine de la réponse.
+// Start / End sont les bornes inclusives de la plage stockée (même famille que l'adresse).
+// SourceFile / SourceLine / RunID / ImportedAt décrivent la provenance de la plage (voir Provenance);
+// ils restent vides lorsque la base ne la conserve pas (plage antérieure au suivi, fallback texte).
+type Result struct {
+	Country    string
+	Start      netip.Addr
+	End        netip.Addr
+	SourceFile string
+	SourceLine int
+	RunID      string
+	ImportedAt time.Time
+	Source     LookupSource
+}
+
+// Prefixes retourne la plage retenue sous forme de préfixes CIDR (un seul si elle est alignée).
+func (r Result) Prefixes() []netip.Prefix {
+	if !r.Start.IsValid() {
+		return nil
+	}
+	return rangeToPrefixes(r.Start, r.End)
+}
+
+// String retourne "pays start-end (source)", ex: "FR 1.0.0.0-1.0.0.255 (numeric)".
+func (r Result) String() string {
+	return fmt.Sprintf("%s %s-%s (%s)", r.Country, r.Start, r.End, r.Source)
+}
+
+// lookupDetailed résout ip comme lookupCountryByIP et retourne la plage retenue et le chemin suivi.
+// La provenance est lue dans une transaction distincte, y compris pour une réponse du cache.
+// En cas d'erreur, Source indique le dernier chemin essayé.
+func (l *IPLocator) lookupDetailed(ip string) (Result, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		l.metrics.invalid.Add(1)
+		return Result{}, fmt.Errorf("%w: %q", ErrInvalidIP, ip)
+	}
+
+	match, source, err := l.lookupMatch(addr)
+	if err != nil {
+		return Result{Source: source}, err
+	}
+
+	result := Result{
+		Country: match.country,
+		Start:   match.start,
+		End:     match.end,
+		Source:  source,
+	}
+
+	key := append(match.start.AsSlice(), match.end.AsSlice()...)
+	err = l.DBManager.DB.View(func(tx *bbolt.Tx) error {
+		if p, found := lookupProvenance(tx, key); found {
+			result.SourceFile = p.Source
+			result.SourceLine = p.Line
+			result.RunID = p.RunID
+			result.ImportedAt = p.ImportedAt
+		}
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,104 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestLookupDetailed(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "FR")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("2001:db8::/32"), "DE")
+
+	start, end, _ := parseIPRange("5.0.0.1-5.0.0.6")
+	manager.upsertIPRangeCountry("5.0.0.1-5.0.0.6", start, end, "US")
+
+	// A range only present in the text bucket is resolved by the fallback
+	err := manager.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("ip_ranges")).Put([]byte("2.0.0.0/16"), []byte("CH"))
+	})
+	if err != nil {
+		t.Fatalf("Error adding text range: %v", err)
+	}
+
+	locator := newIPLocator(manager, 100, WithTextFallback())
+
+	testCases := []struct {
+		ip       string
+		expected string
+		source   LookupSource
+		prefixes []string
+	}{
+		{"1.0.0.7", "FR 1.0.0.0-1.0.0.255 (numeric)", SourceNumeric, []string{"1.0.0.0/24"}},
+		{"1.0.0.7", "FR 1.0.0.0-1.0.0.255 (cache)", SourceCache, []string{"1.0.0.0/24"}},
+		{"::ffff:1.0.0.8", "FR 1.0.0.0-1.0.0.255 (numeric)", SourceNumeric, []string{"1.0.0.0/24"}},
+		{"5.0.0.3", "US 5.0.0.1-5.0.0.6 (numeric)", SourceNumeric, []string{"5.0.0.1/32", "5.0.0.2/31", "5.0.0.4/31", "5.0.0.6/32"}},
+		{"2.0.1.1", "CH 2.0.0.0-2.0.255.255 (text)", SourceText, []string{"2.0.0.0/16"}},
+		{"2001:db8::1", "DE 2001:db8::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff (numeric)", SourceNumeric, []string{"2001:db8::/32"}},
+	}
+
+	for _, tc := range testCases {
+		result, err := locator.lookupDetailed(tc.ip)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.ip, err)
+			continue
+		}
+		if result.String() != tc.expected || result.Source != tc.source {
+			t.Errorf("%s: expected %s, got %s", tc.ip, tc.expected, result)
+		}
+
+		prefixes := result.Prefixes()
+		if len(prefixes) != len(tc.prefixes) {
+			t.Errorf("%s: expected prefixes %v, got %v", tc.ip, tc.prefixes, prefixes)
+			continue
+		}
+		for i, p := range prefixes {
+			if p.String() != tc.prefixes[i] {
+				t.Errorf("%s: expected prefixes %v, got %v", tc.ip, tc.prefixes, prefixes)
+				break
+			}
+		}
+	}
+
+	// Errors keep the last path tried
+	result, err := locator.lookupDetailed("9.9.9.9")
+	if !errors.Is(err, ErrNotFound) || result.Source != SourceText || result.Country != "" {
+		t.Errorf("Incorrect not-found result: %v, %v", result, err)
+	}
+	if _, err := locator.lookupDetailed("bogus"); !errors.Is(err, ErrInvalidIP) {
+		t.Errorf("Expected ErrInvalidIP, got: %v", err)
+	}
+}
+
+func TestLookupDetailedMemory(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "FR")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("2001:db8::/32"), "DE")
+
+	locator, err := newMemoryIPLocator(manager, 100)
+	if err != nil {
+		t.Fatalf("Failed to create memory locator: %v", err)
+	}
+
+	for _, expected := range []string{
+		"DE 2001:db8::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff (memory)",
+		"DE 2001:db8::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff (cache)",
+	} {
+		result, err := locator.lookupDetailed("2001:db8::1")
+		if err != nil || result.String() != expected {
+			t.Errorf("Expected %s, got %s (%v)", expected, result, err)
+		}
+	}
+
+	result, _ := locator.lookupDetailed("1.0.0.1")
+	if result.Start != netip.MustParseAddr("1.0.0.0") || result.End != netip.MustParseAddr("1.0.0.255") {
+		t.Errorf("Incorrect IPv4 bounds: %s", result)
+	}
+}
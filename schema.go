@@ -0,0 +1,200 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// schemaBucket contient les métadonnées de la base (version du schéma).
+const schemaBucket = "meta"
+
+// schemaVersionKey est la clé de la version du schéma (uint32 big-endian) dans schemaBucket.
+const schemaVersionKey = "schema_version"
+
+// migration fait passer la base à version; apply s'exécute dans la transaction commune
+// à toutes les migrations en attente et doit être idempotente (bases antérieures au versionnage).
+type migration struct {
+	version     int
+	description string
+	apply       func(tx *bbolt.Tx) error
+}
+
+// migrations liste les évolutions du schéma, dans l'ordre; la version courante est celle de la dernière.
+// Une base sans bucket meta est en version 0.
+var migrations = []migration{
+	{1, "create ip_ranges, ip_ranges_numeric and ip_prefix_index buckets", createBuckets("ip_ranges", "ip_ranges_numeric", "ip_prefix_index")},
+	{2, "create ip_ranges_numeric_v6 bucket", createBuckets("ip_ranges_numeric_v6")},
+	{3, "create ip_ranges_meta provenance bucket", createBuckets("ip_ranges_meta")},
+	{4, "build ip_prefix_index from ip_ranges_numeric", func(tx *bbolt.Tx) error {
+		return rebuildPrefixIndex(tx, liveBuckets.numeric, liveBuckets.index)
+	}},
+	{5, "build ip_ranges_country from ip_ranges", func(tx *bbolt.Tx) error {
+		return rebuildCountryIndex(tx, liveBuckets.text, liveBuckets.country)
+	}},
+	{6, "rewrite ip_ranges keys in canonical form", func(tx *bbolt.Tx) error {
+		if err := canonicalizeTextRanges(tx, liveBuckets); err != nil {
+			return err
+		}
+		return rebuildCountryIndex(tx, liveBuckets.text, liveBuckets.country)
+	}},
+	// Earlier versions stored overlapping ranges as they came, which the Seek lookup no longer resolves
+	{7, "split overlapping numeric ranges", func(tx *bbolt.Tx) error {
+		if err := flattenRanges(tx); err != nil {
+			return err
+		}
+		return rebuildPrefixIndex(tx, liveBuckets.numeric, liveBuckets.index)
+	}},
+}
+
+// currentSchemaVersion est la version attendue par cette bibliothèque.
+var currentSchemaVersion = migrations[len(migrations)-1].version
+
+// errDryRun annule la transaction de migration en mode simulation.
+var errDryRun = errors.New("migration dry run")
+
+// MigrationStep décrit une migration appliquée (ou simulée) à l'ouverture.
+type MigrationStep struct {
+	Version     int
+	Description string
+}
+
+// MigrationReport résume la mise à jour du schéma faite par OpenDatabase: version trouvée (From),
+// version atteinte (To) et migrations appliquées. En simulation (DryRun), To et Applied décrivent ce qui
+// serait fait mais la base reste inchangée.
+type MigrationReport struct {
+	From    int
+	To      int
+	Applied []MigrationStep
+	DryRun  bool
+}
+
+// createBuckets retourne une migration créant les buckets manquants.
+func createBuckets(names ...string) func(tx *bbolt.Tx) error {
+	return func(tx *bbolt.Tx) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return fmt.Errorf("error creating bucket %s: %v", name, err)
+			}
+		}
+		return nil
+	}
+}
+
+// readSchemaVersion lit la version du schéma (0 si la base n'est pas versionnée).
+func readSchemaVersion(tx *bbolt.Tx) (int, error) {
+	bucket := tx.Bucket([]byte(schemaBucket))
+	if bucket == nil {
+		return 0, nil
+	}
+
+	v := bucket.Get([]byte(schemaVersionKey))
+	if v == nil {
+		return 0, nil
+	}
+	if len(v) != 4 {
+		return 0, fmt.Errorf("invalid schema version record (%d bytes)", len(v))
+	}
+
+	return int(decodeUint32BE(v)), nil
+}
+
+// writeSchemaVersion enregistre la version du schéma.
+func writeSchemaVersion(tx *bbolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(schemaBucket))
+	if err != nil {
+		return fmt.Errorf("error creating bucket %s: %v", schemaBucket, err)
+	}
+
+	var v [4]byte
+	encodeUint32BE(v[:], uint32(version))
+	return bucket.Put([]byte(schemaVersionKey), v[:])
+}
+
+// schemaTooNew construit l'erreur d'une base créée par une version plus récente de la bibliothèque.
+func schemaTooNew(version int) error {
+	return fmt.Errorf("%w: schema version %d, this library supports up to %d", ErrSchemaTooNew, version, currentSchemaVersion)
+}
+
+// migrateSchema applique les migrations en attente dans une seule transaction: en cas d'échec
+// la base reste dans sa version d'origine. En simulation (dryRun) la transaction est annulée.
+func (m *DBManager) migrateSchema(dryRun bool) (*MigrationReport, error) {
+	report := &MigrationReport{DryRun: dryRun}
+
+	err := m.DB.Update(func(tx *bbolt.Tx) error {
+		from, err := readSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if from > currentSchemaVersion {
+			return schemaTooNew(from)
+		}
+
+		report.From, report.To = from, from
+		for _, mig := range migrations[from:] {
+			if err := mig.apply(tx); err != nil {
+				return fmt.Errorf("schema migration %d (%s) failed: %w", mig.version, mig.description, err)
+			}
+			report.Applied = append(report.Applied, MigrationStep{Version: mig.version, Description: mig.description})
+			report.To = mig.version
+		}
+
+		if len(report.Applied) == 0 {
+			return nil
+		}
+		if err := writeSchemaVersion(tx, report.To); err != nil {
+			return err
+		}
+
+		// Every migration ran: roll them back without touching the file
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range report.Applied {
+		m.logger.Info("schema migration", "path", m.DBPath, "version", step.Version,
+			"description", step.Description, "dry_run", dryRun)
+	}
+
+	return report, nil
+}
+
+// checkSchema vérifie, sans la modifier, que la base est à la version courante (ouverture en lecture seule).
+// Retourne ErrSchemaOutdated si des migrations sont en attente, ErrSchemaTooNew si la base est plus récente.
+func (m *DBManager) checkSchema() error {
+	return m.DB.View(func(tx *bbolt.Tx) error {
+		version, err := readSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case version > currentSchemaVersion:
+			return schemaTooNew(version)
+		case version < currentSchemaVersion:
+			return fmt.Errorf("%w: schema version %d, expected %d (open the database read-write once to migrate it)",
+				ErrSchemaOutdated, version, currentSchemaVersion)
+		}
+		return nil
+	})
+}
+
+// schemaVersion retourne la version du schéma enregistrée dans la base.
+func (m *DBManager) schemaVersion() (int, error) {
+	var version int
+	err := m.DB.View(func(tx *bbolt.Tx) error {
+		var err error
+		version, err = readSchemaVersion(tx)
+		return err
+	})
+	return version, err
+}
@@ -0,0 +1,245 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// createLegacyDB crée une base non versionnée (antérieure à IPv6 et au suivi de provenance)
+// contenant la plage 1.0.0.0/24 -> FR.
+func createLegacyDB(t *testing.T) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Error creating legacy database: %v", err)
+	}
+	defer db.Close()
+
+	start, end, _ := parseIPRange("1.0.0.0/24")
+	err = db.Update(func(tx *bbolt.Tx) error {
+		text, err := tx.CreateBucket([]byte("ip_ranges"))
+		if err != nil {
+			return err
+		}
+		numeric, err := tx.CreateBucket([]byte("ip_ranges_numeric"))
+		if err != nil {
+			return err
+		}
+		if err := text.Put([]byte("1.0.0.0/24"), []byte("FR")); err != nil {
+			return err
+		}
+		return numeric.Put(ipv4RangeKey(start, end), []byte("FR"))
+	})
+	if err != nil {
+		t.Fatalf("Error populating legacy database: %v", err)
+	}
+
+	return dbPath
+}
+
+func TestSchemaMigration(t *testing.T) {
+	dbPath := createLegacyDB(t)
+
+	// Read-only opens refuse an outdated schema
+	if _, err := openDatabase(dbPath, true); !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatalf("Expected ErrSchemaOutdated for a read-only open, got %v", err)
+	}
+
+	// A dry run describes the migrations without applying them
+	manager, err := openDatabase(dbPath, false, WithMigrationDryRun())
+	if err != nil {
+		t.Fatalf("Error opening database in dry-run mode: %v", err)
+	}
+	report := manager.Migration()
+	if report == nil || !report.DryRun || report.From != 0 || report.To != currentSchemaVersion || len(report.Applied) != len(migrations) {
+		t.Errorf("Incorrect dry-run report: %+v", report)
+	}
+	if version, err := manager.schemaVersion(); err != nil || version != 0 {
+		t.Errorf("Dry run must not change the schema version, got %d (err: %v)", version, err)
+	}
+	if _, err := manager.upsertPrefixCountry(netip.MustParsePrefix("2.0.0.0/24"), "DE"); !errors.Is(err, ErrSchemaOutdated) {
+		t.Errorf("Expected ErrSchemaOutdated for a write after a dry run, got %v", err)
+	}
+	manager.closeDatabase()
+
+	// A read-write open migrates and keeps the data
+	logger := &recordingLogger{}
+	manager, err = openDatabase(dbPath, false, WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Error migrating database: %v", err)
+	}
+	report = manager.Migration()
+	if report.DryRun || report.From != 0 || report.To != currentSchemaVersion || len(report.Applied) != len(migrations) {
+		t.Errorf("Incorrect migration report: %+v", report)
+	}
+	if e, ok := logger.find("INFO", "schema migration"); !ok || e.attrs["version"] != 1 {
+		t.Errorf("Expected a schema migration log entry, got %+v", e)
+	}
+	if version, _ := manager.schemaVersion(); version != currentSchemaVersion {
+		t.Errorf("Incorrect schema version. Expected: %d, Got: %d", currentSchemaVersion, version)
+	}
+
+	locator := newIPLocator(manager, 0)
+	if country, err := locator.lookupCountryByIP("1.0.0.1"); err != nil || country != "FR" {
+		t.Errorf("Legacy data lost by the migration. Expected: FR, Got: %s (err: %v)", country, err)
+	}
+	if _, err := manager.upsertPrefixCountry(netip.MustParsePrefix("2001:db8::/32"), "DE"); err != nil {
+		t.Errorf("Error writing to the migrated database: %v", err)
+	}
+	manager.closeDatabase()
+
+	// Up-to-date databases open in both modes with nothing to apply
+	manager, err = openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error reopening database: %v", err)
+	}
+	if report := manager.Migration(); report.From != currentSchemaVersion || len(report.Applied) != 0 {
+		t.Errorf("No migration expected on reopen, got %+v", report)
+	}
+	manager.closeDatabase()
+
+	manager, err = openDatabase(dbPath, true)
+	if err != nil {
+		t.Fatalf("Error opening migrated database read-only: %v", err)
+	}
+	if manager.Migration() != nil {
+		t.Error("Read-only opens should not report migrations")
+	}
+	manager.closeDatabase()
+}
+
+func TestSchemaTooNew(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "future.db")
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Error creating database: %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return writeSchemaVersion(tx, currentSchemaVersion+1)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Error writing schema version: %v", err)
+	}
+
+	for _, readOnly := range []bool{true, false} {
+		if _, err := openDatabase(dbPath, readOnly); !errors.Is(err, ErrSchemaTooNew) {
+			t.Errorf("readOnly=%v: expected ErrSchemaTooNew, got %v", readOnly, err)
+		}
+	}
+}
+
+func TestCanonicalizeMigration(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	manager, err := openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	manager.upsertRangeString("1.0.0.0/24", "FR")
+	manager.upsertRangeString("2.0.0.0/24", "DE")
+	manager.closeDatabase()
+
+	// Text keys written before canonicalization, with a stale country on a duplicate spelling
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Error reopening database: %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		text := tx.Bucket([]byte("ip_ranges"))
+		for k, v := range map[string]string{"1.0.0.0-1.0.0.255": "FR", "2.0.0.7/24": "IT", "bogus": "XX"} {
+			if err := text.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		if err := text.Delete([]byte("1.0.0.0/24")); err != nil {
+			return err
+		}
+		return writeSchemaVersion(tx, 5)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Error writing legacy keys: %v", err)
+	}
+
+	manager, err = openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error migrating database: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	if report := manager.Migration(); report.From != 5 || len(report.Applied) != currentSchemaVersion-5 {
+		t.Errorf("Expected the migrations from 5, got %+v", report)
+	}
+
+	var keys []string
+	manager.DB.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("ip_ranges")).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k)+"="+string(v))
+			return nil
+		})
+	})
+	if expected := []string{"1.0.0.0/24=FR", "2.0.0.0/24=DE", "bogus=XX"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Incorrect text keys. Expected: %v, Got: %v", expected, keys)
+	}
+	if report, err := manager.checkIndex(false); err != nil || !report.Consistent() {
+		t.Errorf("Inconsistent index after migration: %+v (err: %v)", report, err)
+	}
+}
+
+func TestFlattenMigration(t *testing.T) {
+	// Unversioned database where a nested range was stored as it came
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := bbolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Error creating legacy database: %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		text, err := tx.CreateBucket([]byte("ip_ranges"))
+		if err != nil {
+			return err
+		}
+		numeric, err := tx.CreateBucket([]byte("ip_ranges_numeric"))
+		if err != nil {
+			return err
+		}
+		for _, r := range []struct{ rangeText, country string }{{"1.0.0.0/16", "DE"}, {"1.0.5.0/24", "FR"}} {
+			start, end, _ := parseIPRange(r.rangeText)
+			if err := text.Put([]byte(r.rangeText), []byte(r.country)); err != nil {
+				return err
+			}
+			if err := numeric.Put(ipv4RangeKey(start, end), []byte(r.country)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Error populating legacy database: %v", err)
+	}
+
+	manager, err := openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error migrating database: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	// Same answers as before the upgrade
+	locator := newIPLocator(manager, 0)
+	for ip, expected := range map[string]string{"1.0.0.1": "DE", "1.0.5.1": "FR", "1.0.9.1": "DE", "1.0.255.255": "DE"} {
+		country, err := locator.lookupCountryByIP(ip)
+		if err != nil || country != expected {
+			t.Errorf("Incorrect result for %s after migration. Expected: %q, Got: %q (err: %v)", ip, expected, country, err)
+		}
+	}
+	if report, err := manager.checkIndex(false); err != nil || !report.Consistent() {
+		t.Errorf("Inconsistent index after migration: %+v (err: %v)", report, err)
+	}
+}
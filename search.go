@@ -1,166 +1,430 @@
 package ipcountrylocator
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"net"
-	"sync"
+	"net/netip"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
 
-// IPCache fournit un cache (clé: IP string -> code pays) à taille bornée, réinitialisé quand plein.
-type IPCache struct {
-	cache       map[string]string
-	maxSize     int
-	currentSize int
-	mutex       sync.RWMutex
+// IPLocator encapsule l'accès DB + cache pour résoudre le pays d'une IPv4 ou IPv6.
+// NegativeCache mémorise les adresses sans plage correspondante (WithNegativeCache, désactivé par défaut).
+// metrics accumule les compteurs exposés par Stats.
+// En mode mémoire, table contient un instantané des buckets numériques et BoltDB n'est plus lu.
+// textFallback active le scan du bucket texte après un échec de l'index numérique (WithTextFallback).
+// subscription identifie l'abonnement aux modifications du DBManager, retiré par close.
+type IPLocator struct {
+	DBManager     *DBManager
+	Cache         *IPCache
+	NegativeCache *IPCache
+	table         atomic.Pointer[rangeTable]
+	metrics       locatorMetrics
+	textFallback  bool
+	subscription  atomic.Uint64
 }
 
-// newIPCache instancie un cache.
-func newIPCache(maxSize int) *IPCache {
-	return &IPCache{
-		cache:       make(map[string]string, maxSize),
-		maxSize:     maxSize,
-		currentSize: 0,
+// newIPLocator construit un localisateur IP; ses caches sont vidés après chaque modification des données du DBManager.
+// cacheSize <= 0 (ou WithoutCache) désactive le cache, WithCacheTTL borne la durée de vie des entrées,
+// WithNegativeCache active le cache des adresses non trouvées, WithTextFallback le scan du bucket texte;
+// WithMemoryTable active le mode mémoire
+// (en cas d'échec du chargement, l'erreur est journalisée et BoltDB reste utilisé).
+func newIPLocator(dbManager *DBManager, cacheSize int, opts ...LocatorOption) *IPLocator {
+	options := newLocatorOptions(opts)
+	if options.noCache {
+		cacheSize = 0
+	}
+
+	locator := &IPLocator{
+		DBManager:     dbManager,
+		Cache:         newIPCache(cacheSize, options.cacheTTL),
+		NegativeCache: newIPCache(options.negativeSize, options.negativeTTL),
+		textFallback:  options.textFallback,
 	}
-}
 
-// getCountry récupère une entrée du cache.
-// Thread-safe (verrou R).
-func (c *IPCache) getCountry(ip string) (string, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	country, found := c.cache[ip]
-	return country, found
+	locator.subscription.Store(dbManager.subscribe(locator.onChange))
+
+	if options.memoryTable {
+		if err := locator.enableMemoryTable(); err != nil {
+			dbManager.logger.Error("in-memory range table load failed", "path", dbManager.DBPath, "error", err)
+		}
+	}
+
+	return locator
 }
 
-// putCountry insère une entrée dans le cache.
-// Thread-safe (verrou W).
-func (c *IPCache) putCountry(ip, country string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// newMemoryIPLocator construit un localisateur IP en mode mémoire.
+// L'instantané est chargé immédiatement puis remplacé après chaque import / upsert du DBManager.
+func newMemoryIPLocator(dbManager *DBManager, cacheSize int, opts ...LocatorOption) (*IPLocator, error) {
+	locator := newIPLocator(dbManager, cacheSize, opts...)
+	if locator.table.Load() != nil {
+		return locator, nil
+	}
 
-	// If the cache is full, clear it
-	if c.currentSize >= c.maxSize {
-		c.cache = make(map[string]string, c.maxSize)
-		c.currentSize = 0
+	if err := locator.enableMemoryTable(); err != nil {
+		return nil, err
 	}
 
-	// Add the entry
-	c.cache[ip] = country
-	c.currentSize++
+	return locator, nil
 }
 
-// IPLocator encapsule l'accès DB + cache pour résoudre le pays d'une IPv4.
-type IPLocator struct {
-	DBManager *DBManager
-	Cache     *IPCache
+// onChange est appelé après chaque modification des données du DBManager: l'instantané mémoire est
+// rechargé et les caches vidés. Un résultat mis en cache ne survit pas à une modification: une plage
+// ajoutée, supprimée ou réaffectée est visible dès la recherche suivante, et une plage ajoutée après
+// un échec n'est pas masquée par le cache négatif.
+func (l *IPLocator) onChange() {
+	if l.table.Load() != nil {
+		if err := l.reloadTable(); err != nil {
+			l.DBManager.logger.Error("in-memory range table reload failed", "path", l.DBManager.DBPath, "error", err)
+		}
+	}
+	l.Cache.reset()
+	l.NegativeCache.reset()
 }
 
-// newIPLocator construit un localisateur IP.
-func newIPLocator(dbManager *DBManager, cacheSize int) *IPLocator {
-	return &IPLocator{
-		DBManager: dbManager,
-		Cache:     newIPCache(cacheSize),
+// close retire l'abonnement du localisateur aux modifications du DBManager, qui ne le référence plus.
+// Sans effet après le premier appel.
+func (l *IPLocator) close() {
+	if id := l.subscription.Swap(0); id != 0 {
+		l.DBManager.unsubscribe(id)
 	}
 }
 
-// lookupCountryByIP recherche le pays pour une IPv4 (cache -> index numérique -> fallback texte).
+// lookupCountryByIP recherche le pays pour une IPv4 ou IPv6
+// (cache -> instantané mémoire ou index numérique -> fallback texte si WithTextFallback).
+// Les adresses IPv4-mapped (::ffff:a.b.c.d) sont résolues via l'index IPv4.
 func (l *IPLocator) lookupCountryByIP(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		l.metrics.invalid.Add(1)
+		return "", fmt.Errorf("%w: %q", ErrInvalidIP, ip)
+	}
+
+	return l.lookupCountryByAddr(addr)
+}
+
+// lookupCountryByAddr recherche le pays d'une adresse déjà parsée (zone IPv6 ignorée).
+// La durée et le chemin de résolution sont enregistrés dans les compteurs du localisateur.
+func (l *IPLocator) lookupCountryByAddr(addr netip.Addr) (string, error) {
+	match, _, err := l.lookupMatch(addr)
+	return match.country, err
+}
+
+// lookupMatch valide et normalise addr, la résout et enregistre durée et chemin de résolution.
+func (l *IPLocator) lookupMatch(addr netip.Addr) (rangeMatch, LookupSource, error) {
+	if !addr.IsValid() {
+		l.metrics.invalid.Add(1)
+		return rangeMatch{}, -1, fmt.Errorf("%w: %q", ErrInvalidIP, addr)
+	}
+
+	start := time.Now()
+
+	match, source, err := l.resolveCountry(addr.Unmap().WithZone(""))
+	l.metrics.latency[source].observe(time.Since(start))
+
+	return match, source, err
+}
+
+// rangeMatch est la plage retenue pour une adresse: pays et bornes inclusives.
+type rangeMatch struct {
+	country string
+	start   netip.Addr
+	end     netip.Addr
+}
+
+// matchFromKey construit un rangeMatch à partir d'une clé numérique start|end et de sa valeur.
+func matchFromKey(k, v []byte) rangeMatch {
+	width := len(k) / 2
+	return rangeMatch{
+		country: internCountry(v),
+		start:   addrFromKeyPart(k[:width]),
+		end:     addrFromKeyPart(k[width:]),
+	}
+}
+
+// resolveCountry effectue la résolution d'une adresse normalisée et retourne la plage retenue
+// et le dernier chemin essayé.
+func (l *IPLocator) resolveCountry(addr netip.Addr) (rangeMatch, LookupSource, error) {
+	// Results read before a change of the data must not be cached after the reset that follows it
+	gen, negativeGen := l.Cache.generation(), l.NegativeCache.generation()
+
 	// First check in the cache
-	if country, found := l.Cache.getCountry(ip); found {
-		return country, nil
+	if match, found := l.Cache.get(addr); found {
+		return match, SourceCache, nil
 	}
 
-	ipAddr := net.ParseIP(ip).To4()
-	if ipAddr == nil {
-		return "", fmt.Errorf("invalid IPv4 address")
+	// Then in the negative cache: known unresolved addresses skip the database
+	if _, found := l.NegativeCache.get(addr); found {
+		l.metrics.notFound.Add(1)
+		return rangeMatch{}, SourceNegativeCache, fmt.Errorf("%w for IP: %s", ErrNotFound, addr)
 	}
 
-	ipNum := ipv4ToUint32(ipAddr)
+	// In memory mode, answer from the snapshot without touching BoltDB
+	if table := l.table.Load(); table != nil {
+		l.metrics.memory.Add(1)
+		match, found := table.lookupAddr(addr)
+		if !found {
+			l.metrics.notFound.Add(1)
+			l.NegativeCache.putAt(addr, rangeMatch{}, negativeGen)
+			return rangeMatch{}, SourceMemory, fmt.Errorf("%w for IP: %s", ErrNotFound, addr)
+		}
+		l.Cache.putAt(addr, match, gen)
+		return match, SourceMemory, nil
+	}
 
-	var country string
+	var match rangeMatch
+	source := SourceNumeric
 	err := l.DBManager.DB.View(func(tx *bbolt.Tx) error {
-		// 1. First try the optimized numeric method
-		countryCode, err := l.lookupCountryByIPNumeric(tx, ipNum)
+		// 1. First try the optimized numeric method for the address family
+		var err error
+		l.metrics.numeric.Add(1)
+		if addr.Is4() {
+			ip4 := addr.As4()
+			match, err = l.lookupCountryByIPNumeric(tx, decodeUint32BE(ip4[:]))
+		} else {
+			match, err = l.lookupCountryByIPv6Numeric(tx, addr.As16())
+		}
 		if err == nil {
-			country = countryCode
 			return nil
 		}
+		if !l.textFallback {
+			if errors.Is(err, ErrNotFound) {
+				return fmt.Errorf("%w for IP: %s", ErrNotFound, addr)
+			}
+			return err
+		}
 
-		// 2. If it doesn't work, use the traditional method
+		// 2. If it doesn't work and the fallback is enabled, use the traditional method
+		source = SourceText
+		l.metrics.text.Add(1)
 		bucket := tx.Bucket([]byte("ip_ranges"))
 		if bucket == nil {
-			return fmt.Errorf("bucket 'ip_ranges' not found")
+			return bucketMissing("ip_ranges")
 		}
 
+		ipKey := addr.AsSlice()
 		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			ipRange := string(k)
-			if ipv4InRange(ip, ipRange) {
-				country = string(v)
+			key, err := parseRangeKey(string(k))
+			if err != nil || len(key) != 2*len(ipKey) {
+				continue
+			}
+			if bytes.Compare(ipKey, key[:len(ipKey)]) >= 0 && bytes.Compare(ipKey, key[len(ipKey):]) <= 0 {
+				match = matchFromKey(key, v)
 				return nil
 			}
 		}
 
-		return fmt.Errorf("no matching country found for IP: %s", ip)
+		return fmt.Errorf("%w for IP: %s", ErrNotFound, addr)
 	})
 
-	// Cache the result if found
+	// Cache the result if found; only genuine misses are cached as negative
 	if err == nil {
-		l.Cache.putCountry(ip, country)
+		l.Cache.putAt(addr, match, gen)
+	} else if errors.Is(err, ErrNotFound) {
+		l.metrics.notFound.Add(1)
+		l.NegativeCache.putAt(addr, rangeMatch{}, negativeGen)
 	}
 
-	return country, err
+	return match, source, err
 }
 
-// lookupCountryByIPNumeric effectue une recherche dans le bucket numérique.
-func (l *IPLocator) lookupCountryByIPNumeric(tx *bbolt.Tx, ipNum uint32) (string, error) {
-	bucket := tx.Bucket([]byte("ip_ranges_numeric"))
-	if bucket == nil {
-		return "", fmt.Errorf("bucket 'ip_ranges_numeric' not found")
+// seekRange recherche la plage contenant addr dans un bucket numérique dont les clés sont
+// start|end (chacun sur len(addr) octets, big-endian).
+// Le curseur est positionné par Seek sur start=addr, end=max puis recule sur la plage précédente:
+// seule la plage de plus grand start <= addr est candidate, ce qui suppose des plages disjointes
+// (garanti par les écritures, qui fusionnent ou découpent les plages qui se recouvrent).
+// Retourne la clé et la valeur (code pays) de la plage, ou nil si aucune plage ne contient addr.
+func seekRange(bucket *bbolt.Bucket, addr []byte) ([]byte, []byte) {
+	width := len(addr)
+
+	// Build the seek key: every range starting at addr sorts before or on it
+	var buf [32]byte
+	target := buf[:2*width]
+	copy(target[0:width], addr)
+	for i := width; i < 2*width; i++ {
+		target[i] = 0xFF
 	}
 
-	// Optimized search
 	c := bucket.Cursor()
-	for k, v := c.First(); k != nil; k, v = c.Next() {
-		if len(k) >= 8 {
-			start := decodeUint32BE(k[0:4])
-			end := decodeUint32BE(k[4:8])
+	k, v := c.Seek(target)
+	if k == nil || !bytes.Equal(k, target) {
+		// Step back to the predecessor range (or the last one if we are past the end)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+	}
 
-			if ipNum >= start && ipNum <= end {
-				return string(v), nil
-			}
+	if len(k) == 2*width {
+		start := k[0:width]
+		end := k[width : 2*width]
+
+		if bytes.Compare(addr, start) >= 0 && bytes.Compare(addr, end) <= 0 {
+			return k, v
+		}
+	}
+
+	return nil, nil
+}
+
+// lookupCountryByIPNumeric recherche la plage d'une IPv4: l'index des /16 (ip_prefix_index) est consulté
+// d'abord, puis, si le /16 n'y figure pas, une recherche logarithmique est faite dans le bucket numérique.
+func (l *IPLocator) lookupCountryByIPNumeric(tx *bbolt.Tx, ipNum uint32) (rangeMatch, error) {
+	bucket := tx.Bucket([]byte("ip_ranges_numeric"))
+	if bucket == nil {
+		return rangeMatch{}, bucketMissing("ip_ranges_numeric")
+	}
 
-			// Optimization: if we exceed the IP value, no need to continue
-			if start > ipNum {
-				break
+	var addr [4]byte
+	encodeUint32BE(addr[:], ipNum)
+
+	// A single point lookup settles the /16 when it is indexed, found or not
+	if index := tx.Bucket([]byte("ip_prefix_index")); index != nil {
+		if k, v, ok := lookupPrefixIndex(index, bucket, addr[:]); ok {
+			if k == nil {
+				return rangeMatch{}, ErrNotFound
 			}
+			return matchFromKey(k, v), nil
 		}
 	}
 
-	return "", fmt.Errorf("no matching range found")
+	if k, v := seekRange(bucket, addr[:]); k != nil {
+		return matchFromKey(k, v), nil
+	}
+
+	return rangeMatch{}, ErrNotFound
 }
 
-// listIPRangesByCountry retourne toutes les plages texte associées à un pays.
+// lookupCountryByIPv6Numeric effectue une recherche logarithmique dans le bucket numérique IPv6.
+func (l *IPLocator) lookupCountryByIPv6Numeric(tx *bbolt.Tx, ip [16]byte) (rangeMatch, error) {
+	bucket := tx.Bucket([]byte("ip_ranges_numeric_v6"))
+	if bucket == nil {
+		return rangeMatch{}, bucketMissing("ip_ranges_numeric_v6")
+	}
+
+	if k, v := seekRange(bucket, ip[:]); k != nil {
+		return matchFromKey(k, v), nil
+	}
+
+	return rangeMatch{}, ErrNotFound
+}
+
+// listIPRangesByCountry retourne toutes les plages texte associées à un pays (ordre des clés texte),
+// à partir de l'index par pays: le coût est proportionnel au nombre de plages du pays.
 func (l *IPLocator) listIPRangesByCountry(countryCode string) ([]string, error) {
 	var ranges []string
 
 	err := l.DBManager.DB.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte("ip_ranges"))
-		if bucket == nil {
-			return fmt.Errorf("bucket 'ip_ranges' not found")
-		}
+		return eachCountryRange(tx, countryCode, "", func(rangeText string) bool {
+			ranges = append(ranges, rangeText)
+			return true
+		})
+	})
+
+	return ranges, err
+}
+
+// listIPRangesPage retourne au plus limit plages texte d'un pays, situées après la plage after
+// (depuis le début si after est vide), et la plage à passer comme after pour la page suivante
+// ("" s'il n'y en a plus).
+func (l *IPLocator) listIPRangesPage(countryCode, after string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid page size: %d", limit)
+	}
 
-		c := bucket.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if string(v) == countryCode {
-				ranges = append(ranges, string(k))
+	var ranges []string
+	more := false
+
+	err := l.DBManager.DB.View(func(tx *bbolt.Tx) error {
+		return eachCountryRange(tx, countryCode, after, func(rangeText string) bool {
+			if len(ranges) == limit {
+				more = true
+				return false
 			}
+			ranges = append(ranges, rangeText)
+			return true
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if more {
+		next = ranges[len(ranges)-1]
+	}
+	return ranges, next, nil
+}
+
+// eachIPRange appelle fn pour chaque plage texte d'un pays, dans une seule transaction de lecture;
+// une erreur retournée par fn arrête le parcours et est retournée telle quelle.
+func (l *IPLocator) eachIPRange(countryCode string, fn func(rangeStr string) error) error {
+	var fnErr error
+
+	err := l.DBManager.DB.View(func(tx *bbolt.Tx) error {
+		return eachCountryRange(tx, countryCode, "", func(rangeText string) bool {
+			fnErr = fn(rangeText)
+			return fnErr == nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+// listPrefixesByCountry retourne les plages numériques (IPv4 puis IPv6, ordre croissant) d'un pays sous forme
+// de préfixes CIDR, à partir de l'index par pays: le coût est proportionnel au nombre de plages du pays.
+// Une plage "start-end" non alignée est décomposée en plusieurs préfixes.
+func (l *IPLocator) listPrefixesByCountry(countryCode string) ([]netip.Prefix, error) {
+	var keys [][]byte
+
+	err := l.DBManager.DB.View(func(tx *bbolt.Tx) error {
+		countries := tx.Bucket([]byte(liveBuckets.country))
+		if countries == nil {
+			return bucketMissing(liveBuckets.country)
 		}
 
-		return nil
+		nested := countries.Bucket([]byte(countryCode))
+		if nested == nil || countryCode == "" {
+			return nil
+		}
+
+		return nested.ForEach(func(_, v []byte) error {
+			// Unparseable text ranges have no numeric key
+			if len(v) == 8 || len(v) == 32 {
+				keys = append(keys, append([]byte(nil), v...))
+			}
+			return nil
+		})
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return ranges, err
+	// Text keys are not in numeric order: IPv4 keys first, then by start
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	var prefixes []netip.Prefix
+	for i, k := range keys {
+		if i > 0 && bytes.Equal(k, keys[i-1]) {
+			continue
+		}
+		width := len(k) / 2
+		prefixes = append(prefixes, rangeToPrefixes(addrFromKeyPart(k[:width]), addrFromKeyPart(k[width:]))...)
+	}
+
+	return prefixes, nil
 }
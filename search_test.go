@@ -1,46 +1,16 @@
 package ipcountrylocator
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
 	"testing"
-)
-
-func TestIPCache(t *testing.T) {
-	// Create a cache with a maximum size of 3 entries
-	cache := newIPCache(3)
-
-	// Test adding elements
-	cache.putCountry("192.168.1.1", "FR")
-	cache.putCountry("10.0.0.1", "DE")
-	country, found := cache.getCountry("192.168.1.1")
-
-	if !found {
-		t.Error("The entry should exist in the cache")
-	}
-
-	if country != "FR" {
-		t.Errorf("Incorrect country for IP. Expected: FR, Got: %s", country)
-	}
-
-	// Test a non-existent element
-	_, found = cache.getCountry("8.8.8.8")
-	if found {
-		t.Error("The entry should not exist in the cache")
-	}
-
-	// Test cache overflow
-	cache.putCountry("172.16.0.1", "US")
-	cache.putCountry("8.8.8.8", "US") // Should reset the cache
-
-	_, found = cache.getCountry("192.168.1.1")
-	if found {
-		t.Error("The entry should have been removed during cache reset")
-	}
+	"time"
 
-	country, found = cache.getCountry("8.8.8.8")
-	if !found || country != "US" {
-		t.Error("The new entry was not correctly added after cache reset")
-	}
-}
+	"go.etcd.io/bbolt"
+)
 
 func TestNewIPLocator(t *testing.T) {
 	manager, _, cleanup := setupTestDB(t)
@@ -112,7 +82,7 @@ func TestFindCountryForIP(t *testing.T) {
 			}
 
 			// Check that the IP is cached
-			cachedCountry, found := locator.Cache.getCountry(tc.ip)
+			cachedCountry, found := locator.Cache.getCountry(netip.MustParseAddr(tc.ip))
 			if !found {
 				t.Errorf("IP %s was not cached", tc.ip)
 			}
@@ -129,17 +99,83 @@ func TestFindCountryForIP(t *testing.T) {
 	}
 
 	// Test search from cache
-	// Modify a range to verify that the cache is used
+	if _, source, err := locator.lookupMatch(netip.MustParseAddr("1.0.0.123")); err != nil || source != SourceCache {
+		t.Errorf("The cache was not used. Got source: %v (err: %v)", source, err)
+	}
+
+	// Modify a range: the cached answer must not outlive the change
 	start, end, _ := parseIPRange("1.0.0.0-1.0.0.255")
 	_, err := manager.upsertIPRangeCountry("1.0.0.0-1.0.0.255", start, end, "IT")
 	if err != nil {
 		t.Fatalf("Error updating IP range: %v", err)
 	}
 
-	// The search should still return FR because the entry is cached
 	country, err := locator.lookupCountryByIP("1.0.0.123")
-	if err != nil || country != "FR" {
-		t.Errorf("The cache was not used correctly. Expected: FR, Got: %s", country)
+	if err != nil || country != "IT" {
+		t.Errorf("The cache was not refreshed after an upsert. Expected: IT, Got: %s", country)
+	}
+}
+
+func TestFindCountryForIPv6(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Add test IPv4 and IPv6 ranges
+	ipRanges := []struct {
+		ipRange string
+		country string
+	}{
+		{"2001:db8::/32", "FR"},
+		{"2a00:1450::-2a00:1450:ffff:ffff:ffff:ffff:ffff:ffff", "DE"},
+		{"8.8.8.0-8.8.8.255", "US"},
+	}
+
+	for _, r := range ipRanges {
+		if start, end, err := parseIPRange(r.ipRange); err == nil {
+			_, err = manager.upsertIPRangeCountry(r.ipRange, start, end, r.country)
+			if err != nil {
+				t.Fatalf("Error adding IP range: %v", err)
+			}
+			continue
+		}
+
+		start, end, err := parseIPv6Range(r.ipRange)
+		if err != nil {
+			t.Fatalf("Error parsing IPv6 range %s: %v", r.ipRange, err)
+		}
+		if _, err := manager.upsertIPv6RangeCountry(r.ipRange, start, end, r.country); err != nil {
+			t.Fatalf("Error adding IPv6 range: %v", err)
+		}
+	}
+
+	locator := newIPLocator(manager, 100)
+
+	testCases := []struct {
+		ip              string
+		expectedCountry string
+		shouldFind      bool
+	}{
+		{"2001:db8::1", "FR", true},
+		{"2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", "FR", true},
+		{"2a00:1450:4007:80e::200e", "DE", true},
+		{"::ffff:8.8.8.8", "US", true}, // IPv4-mapped address
+		{"8.8.8.8", "US", true},
+		{"2001:db9::1", "", false}, // IPv6 outside known ranges
+		{"::1", "", false},
+		{"not-an-ip", "", false},
+	}
+
+	for _, tc := range testCases {
+		country, err := locator.lookupCountryByIP(tc.ip)
+
+		if tc.shouldFind {
+			if err != nil || country != tc.expectedCountry {
+				t.Errorf("Incorrect country for %s. Expected: %s, Got: %s (err: %v)",
+					tc.ip, tc.expectedCountry, country, err)
+			}
+		} else if err == nil {
+			t.Errorf("The search for %s should have failed", tc.ip)
+		}
 	}
 }
 
@@ -180,8 +216,8 @@ func TestGetAllRangesForCountry(t *testing.T) {
 
 	// Check that the ranges are correct
 	expectedRanges := map[string]bool{
-		"1.0.0.0-1.0.0.255": true,
-		"1.1.0.0-1.1.0.255": true,
+		"1.0.0.0/24": true,
+		"1.1.0.0/24": true,
 	}
 
 	for _, r := range ranges {
@@ -200,3 +236,475 @@ func TestGetAllRangesForCountry(t *testing.T) {
 		t.Errorf("Incorrect number of ranges for IT. Expected: 0, Got: %d", len(ranges))
 	}
 }
+
+func TestLookupNumericBoundaries(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Ranges touching both ends of the address space, with gaps in between
+	ipRanges := []struct {
+		ipRange string
+		country string
+	}{
+		{"0.0.0.0-0.255.255.255", "AD"},
+		{"1.0.0.0-1.0.0.255", "FR"},
+		{"1.0.1.0-1.0.1.0", "DE"},
+		{"1.0.2.0-1.0.3.255", "IT"},
+		{"255.255.255.0-255.255.255.255", "ZW"},
+	}
+
+	for _, r := range ipRanges {
+		start, end, _ := parseIPRange(r.ipRange)
+		_, err := manager.upsertIPRangeCountry(r.ipRange, start, end, r.country)
+		if err != nil {
+			t.Fatalf("Error adding IP range: %v", err)
+		}
+	}
+
+	locator := newIPLocator(manager, 100)
+
+	testCases := []struct {
+		ip              string
+		expectedCountry string
+		shouldFind      bool
+	}{
+		{"0.0.0.0", "AD", true},
+		{"0.255.255.255", "AD", true},
+		{"1.0.0.0", "FR", true},   // Exact start
+		{"1.0.0.255", "FR", true}, // Exact end
+		{"1.0.1.0", "DE", true},   // Single address range
+		{"1.0.1.1", "", false},    // Gap between ranges
+		{"1.0.3.128", "IT", true},
+		{"1.0.4.0", "", false},
+		{"255.255.255.255", "ZW", true}, // Last key of the bucket
+		{"255.255.254.255", "", false},
+	}
+
+	err := manager.DB.View(func(tx *bbolt.Tx) error {
+		for _, tc := range testCases {
+			match, err := locator.lookupCountryByIPNumeric(tx, ipv4ToUint32(net.ParseIP(tc.ip)))
+			country := match.country
+			if tc.shouldFind {
+				if err != nil || country != tc.expectedCountry {
+					t.Errorf("Incorrect country for %s. Expected: %s, Got: %s (err: %v)",
+						tc.ip, tc.expectedCountry, country, err)
+				}
+			} else if err == nil {
+				t.Errorf("The search for %s should have failed, got %s", tc.ip, country)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Error during lookups: %v", err)
+	}
+}
+
+func TestLookupNestedRanges(t *testing.T) {
+	testCases := []struct {
+		name     string
+		lines    []string
+		expected []string // FR ranges once merged
+	}{
+		{"outer first", []string{"1.0.0.0/8", "1.0.1.0/24", "2001:db8::/32", "2001:db8:1::/48"}, []string{"1.0.0.0/8", "2001:db8::/32"}},
+		{"inner first", []string{"1.0.1.0/24", "1.0.0.0/8", "2001:db8:1::/48", "2001:db8::/32"}, []string{"1.0.0.0/8", "2001:db8::/32"}},
+		{"partial", []string{"1.0.0.0-1.0.1.255", "1.0.1.0-1.255.255.255", "2001:db8::/32"}, []string{"1.0.0.0/24", "1.0.1.0-1.255.255.255", "2001:db8::/32"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			manager, tempDir, cleanup := setupTestDB(t)
+			defer cleanup()
+
+			filePath, err := createTestZoneFile(tempDir, "FR", tc.lines)
+			if err != nil {
+				t.Fatalf("Failed to create test file: %v", err)
+			}
+			if _, err := manager.importZoneFile(filePath); err != nil {
+				t.Fatalf("Error processing file: %v", err)
+			}
+
+			// No text fallback: the numeric lookup alone must reach the outer ranges
+			locator := newIPLocator(manager, 0)
+			for _, ip := range []string{"1.0.0.1", "1.0.1.1", "1.0.2.1", "1.5.0.1", "2001:db8::1", "2001:db8:1::1", "2001:db8:2::1"} {
+				if country, err := locator.lookupCountryByIP(ip); err != nil || country != "FR" {
+					t.Errorf("Incorrect country for %s. Expected: FR, Got: %q (err: %v)", ip, country, err)
+				}
+			}
+
+			ranges, _ := locator.listIPRangesByCountry("FR")
+			if !reflect.DeepEqual(ranges, tc.expected) {
+				t.Errorf("Incorrect FR ranges. Expected: %v, Got: %v", tc.expected, ranges)
+			}
+		})
+	}
+
+	// Upserts: a range of another country is carved out of the enclosing one
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertRangeString("1.0.0.0/16", "FR")
+	manager.upsertRangeString("1.0.5.0/24", "DE")
+	manager.upsertRangeString("1.0.9.0/24", "FR")
+
+	locator := newIPLocator(manager, 0)
+	for ip, expected := range map[string]string{"1.0.1.1": "FR", "1.0.5.1": "DE", "1.0.6.1": "FR", "1.0.9.1": "FR", "1.0.200.1": "FR"} {
+		if country, err := locator.lookupCountryByIP(ip); err != nil || country != expected {
+			t.Errorf("Incorrect country for %s. Expected: %s, Got: %q (err: %v)", ip, expected, country, err)
+		}
+	}
+
+	ranges, _ := locator.listIPRangesByCountry("FR")
+	if expected := []string{"1.0.0.0-1.0.4.255", "1.0.6.0-1.0.255.255"}; !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Incorrect FR ranges after upserts. Expected: %v, Got: %v", expected, ranges)
+	}
+}
+
+// populateBenchmarkRanges insère une /24 au début de chaque /18 pour couvrir tout l'espace IPv4.
+func populateBenchmarkRanges(b *testing.B, manager *DBManager) int {
+	batch := make([]zoneEntry, 0)
+
+	for i := uint32(0); i < 1<<14; i++ {
+		start := i << 18
+		end := start | 0xFF
+		batch = append(batch, zoneEntry{
+			Text:    fmt.Sprintf("%d.%d.0.0/24", start>>24, (start>>16)&0xFF),
+			Country: "FR",
+			Key:     ipv4RangeKey(start, end),
+		})
+	}
+
+	if err := manager.writeBatch(batch, newImportState(ConflictLastFileWins), &FileReport{}); err != nil {
+		b.Fatalf("Error populating database: %v", err)
+	}
+
+	return len(batch)
+}
+
+// BenchmarkLookupNumeric mesure la recherche numérique (hors cache) au début, au milieu et à la fin de l'espace IPv4,
+// par seekRange seul (seek) et par le chemin complet, index des /16 d'abord (index).
+// La latence doit rester stable quelle que soit l'adresse.
+func BenchmarkLookupNumeric(b *testing.B) {
+	manager, _, cleanup := setupTestDB(b)
+	defer cleanup()
+
+	populateBenchmarkRanges(b, manager)
+	locator := newIPLocator(manager, 100)
+
+	paths := []struct {
+		name   string
+		lookup func(tx *bbolt.Tx, ipNum uint32) error
+	}{
+		{"seek", func(tx *bbolt.Tx, ipNum uint32) error {
+			var addr [4]byte
+			encodeUint32BE(addr[:], ipNum)
+			if k, _ := seekRange(tx.Bucket([]byte("ip_ranges_numeric")), addr[:]); k == nil {
+				return ErrNotFound
+			}
+			return nil
+		}},
+		{"index", func(tx *bbolt.Tx, ipNum uint32) error {
+			_, err := locator.lookupCountryByIPNumeric(tx, ipNum)
+			return err
+		}},
+	}
+
+	for _, path := range paths {
+		for _, ip := range []string{"0.0.0.10", "128.0.0.10", "255.252.0.10"} {
+			ipNum := ipv4ToUint32(net.ParseIP(ip))
+			b.Run(path.name+"/"+ip, func(b *testing.B) {
+				err := manager.DB.View(func(tx *bbolt.Tx) error {
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						if err := path.lookup(tx, ipNum); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					b.Fatalf("Error during lookup: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start, end, _ := parseIPRange("1.0.0.0/24")
+	manager.upsertIPRangeCountry("1.0.0.0/24", start, end, "FR")
+
+	locator := newIPLocator(manager, 100, WithNegativeCache(10, 0), WithTextFallback())
+
+	for i := 0; i < 3; i++ {
+		if _, err := locator.lookupCountryByIP("9.9.9.9"); err == nil {
+			t.Fatal("An unknown IP should not be found")
+		}
+	}
+
+	// Only the first lookup reaches the database
+	st := locator.stats()
+	if st.NumericLookups != 1 || st.TextLookups != 1 {
+		t.Errorf("Repeated misses reached the database: %d numeric, %d text", st.NumericLookups, st.TextLookups)
+	}
+	if st.NegativeCacheHits != 2 || st.NotFound != 3 || st.NegativeCacheSize != 1 {
+		t.Errorf("Incorrect negative cache counters: %+v", st)
+	}
+	if st.CacheSize != 0 || st.Latency[SourceNegativeCache].Count != 2 {
+		t.Errorf("Negative results must be kept apart from hits: %+v", st)
+	}
+
+	// A range added afterwards is visible immediately
+	start, end, _ = parseIPRange("9.9.9.0/24")
+	if _, err := manager.upsertIPRangeCountry("9.9.9.0/24", start, end, "CH"); err != nil {
+		t.Fatalf("Error adding IP range: %v", err)
+	}
+	if country, err := locator.lookupCountryByIP("9.9.9.9"); err != nil || country != "CH" {
+		t.Errorf("The negative entry was not invalidated: %s, %v", country, err)
+	}
+}
+
+func TestNegativeCacheTTL(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	locator := newIPLocator(manager, 100, WithNegativeCache(10, 20*time.Millisecond))
+	locator.lookupCountryByIP("9.9.9.9")
+	time.Sleep(40 * time.Millisecond)
+	locator.lookupCountryByIP("9.9.9.9")
+
+	if st := locator.stats(); st.NumericLookups != 2 || st.NegativeCacheExpirations != 1 {
+		t.Errorf("The negative entry did not expire: %+v", st)
+	}
+
+	// Disabled by default
+	locator = newIPLocator(manager, 100)
+	locator.lookupCountryByIP("9.9.9.9")
+	locator.lookupCountryByIP("9.9.9.9")
+	if st := locator.stats(); st.NumericLookups != 2 || st.NegativeCacheCapacity != 0 {
+		t.Errorf("Negative caching should be disabled by default: %+v", st)
+	}
+}
+
+func TestLookupAddr(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "FR")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("2001:db8::/32"), "DE")
+
+	locator := newIPLocator(manager, 100)
+
+	testCases := []struct {
+		addr     netip.Addr
+		expected string
+		err      error
+	}{
+		{netip.MustParseAddr("1.0.0.1"), "FR", nil},
+		{netip.MustParseAddr("::ffff:1.0.0.1"), "FR", nil},
+		{netip.MustParseAddr("2001:db8::1"), "DE", nil},
+		{netip.MustParseAddr("fe80::1%eth0"), "", ErrNotFound},
+		{netip.MustParseAddrPort("1.0.0.9:443").Addr(), "FR", nil},
+		{netip.Addr{}, "", ErrInvalidIP},
+	}
+
+	for _, tc := range testCases {
+		country, err := locator.lookupCountryByAddr(tc.addr)
+		if country != tc.expected || !errors.Is(err, tc.err) {
+			t.Errorf("%v: expected %q (%v), got %q (%v)", tc.addr, tc.expected, tc.err, country, err)
+		}
+	}
+
+	// String and address lookups share the cache
+	if st := locator.stats(); st.CacheHits != 1 {
+		t.Errorf("The IPv4-mapped lookup should hit the IPv4 cache entry: %d hits", st.CacheHits)
+	}
+	if _, found := locator.Cache.getCountry(netip.MustParseAddr("1.0.0.1")); !found {
+		t.Error("The address was not cached")
+	}
+}
+
+func TestRangesFor(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := manager.upsertPrefixCountry(netip.MustParsePrefix("2001:db8::/32"), "FR"); err != nil {
+		t.Fatalf("Error adding prefix: %v", err)
+	}
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.2.3.4/24"), "FR")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("8.8.8.0/24"), "US")
+	// Sorts before 5.0.0.1-5.0.0.6 in text order, after it in numeric order
+	manager.upsertPrefixCountry(netip.MustParsePrefix("20.0.0.0/24"), "FR")
+
+	start, end, _ := parseIPRange("5.0.0.1-5.0.0.6")
+	manager.upsertIPRangeCountry("5.0.0.1-5.0.0.6", start, end, "FR")
+
+	locator := newIPLocator(manager, 100)
+	prefixes, err := locator.listPrefixesByCountry("FR")
+	if err != nil {
+		t.Fatalf("Error listing prefixes: %v", err)
+	}
+
+	expected := []string{"1.2.3.0/24", "5.0.0.1/32", "5.0.0.2/31", "5.0.0.4/31", "5.0.0.6/32", "20.0.0.0/24", "2001:db8::/32"}
+	if len(prefixes) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, prefixes)
+	}
+	for i, p := range prefixes {
+		if p.String() != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, prefixes)
+			break
+		}
+	}
+
+	// The text form is the canonical one: masked CIDR, or "start-end" when not a single prefix
+	ranges, _ := locator.listIPRangesByCountry("FR")
+	if expected := []string{"1.2.3.0/24", "20.0.0.0/24", "2001:db8::/32", "5.0.0.1-5.0.0.6"}; !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Incorrect text ranges. Expected: %v, Got: %v", expected, ranges)
+	}
+}
+
+func TestLookupAllocations(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/16"), "FR")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("2001:db8::/32"), "DE")
+
+	addr := netip.MustParseAddr("1.0.0.1")
+
+	// Cache hits
+	locator := newIPLocator(manager, 100)
+	locator.lookupCountryByIP("1.0.0.1")
+	locator.lookupCountryByIP("2001:db8::1")
+
+	hits := map[string]func(){
+		"string IPv4": func() { locator.lookupCountryByIP("1.0.0.1") },
+		"string IPv6": func() { locator.lookupCountryByIP("2001:db8::1") },
+		"addr":        func() { locator.lookupCountryByAddr(addr) },
+	}
+	for name, fn := range hits {
+		if allocs := testing.AllocsPerRun(1000, fn); allocs != 0 {
+			t.Errorf("Cache hit (%s): %v allocations per lookup", name, allocs)
+		}
+	}
+
+	// Cache misses in memory mode, with insertions and evictions
+	memory, err := newMemoryIPLocator(manager, 64)
+	if err != nil {
+		t.Fatalf("Failed to create memory locator: %v", err)
+	}
+	i := 0
+	miss := func() {
+		i++
+		memory.lookupCountryByAddr(netip.AddrFrom4([4]byte{1, 0, byte(i >> 8), byte(i)}))
+	}
+	for j := 0; j < 1000; j++ {
+		miss()
+	}
+	if allocs := testing.AllocsPerRun(1000, miss); allocs != 0 {
+		t.Errorf("Cache miss (memory): %v allocations per lookup", allocs)
+	}
+
+	// Cache misses on BoltDB: nothing beyond the read transaction itself
+	uncached := newIPLocator(manager, 0)
+	uncached.lookupCountryByAddr(addr)
+	tx := testing.AllocsPerRun(1000, func() {
+		manager.DB.View(func(tx *bbolt.Tx) error {
+			// The /16 index answers with a point lookup, then the range is read back
+			numeric := tx.Bucket([]byte("ip_ranges_numeric"))
+			tx.Bucket([]byte("ip_prefix_index")).Get([]byte{1, 0})
+			numeric.Get([]byte{1, 0, 0, 0, 1, 0, 0xFF, 0xFF})
+			return nil
+		})
+	})
+	if allocs := testing.AllocsPerRun(1000, func() { uncached.lookupCountryByAddr(addr) }); allocs > tx {
+		t.Errorf("Cache miss (BoltDB): %v allocations per lookup, read transaction alone: %v", allocs, tx)
+	}
+}
+
+// BenchmarkLookupHotPath mesure le chemin chaud: hit du cache (chaîne et netip.Addr),
+// miss du cache en mode mémoire et miss du cache sur BoltDB.
+func BenchmarkLookupHotPath(b *testing.B) {
+	manager, _, cleanup := setupTestDB(b)
+	defer cleanup()
+
+	populateBenchmarkRanges(b, manager)
+	addr := netip.MustParseAddr("128.0.0.10")
+
+	cached := newIPLocator(manager, 1000)
+	cached.lookupCountryByAddr(addr)
+
+	memory, err := newMemoryIPLocator(manager, 0)
+	if err != nil {
+		b.Fatalf("Failed to create memory locator: %v", err)
+	}
+	uncached := newIPLocator(manager, 0)
+
+	benchmarks := []struct {
+		name string
+		fn   func() (string, error)
+	}{
+		{"cache-hit-string", func() (string, error) { return cached.lookupCountryByIP("128.0.0.10") }},
+		{"cache-hit-addr", func() (string, error) { return cached.lookupCountryByAddr(addr) }},
+		{"memory-addr", func() (string, error) { return memory.lookupCountryByAddr(addr) }},
+		{"bolt-addr", func() (string, error) { return uncached.lookupCountryByAddr(addr) }},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.fn(); err != nil {
+					b.Fatalf("Error during lookup: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLocatorClose(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "FR")
+
+	listeners := func() int {
+		manager.listenersMu.Lock()
+		defer manager.listenersMu.Unlock()
+		return len(manager.listeners)
+	}
+
+	locator := newIPLocator(manager, 100)
+	memory, err := newMemoryIPLocator(manager, 100, WithNegativeCache(100, 0))
+	if err != nil {
+		t.Fatalf("Error creating memory locator: %v", err)
+	}
+	if n := listeners(); n != 2 {
+		t.Fatalf("Expected one listener per locator, got %d", n)
+	}
+
+	// Closing releases the locator: the manager no longer calls it
+	memory.close()
+	memory.close()
+	if n := listeners(); n != 1 {
+		t.Errorf("Expected 1 listener after Close, got %d", n)
+	}
+	memory.lookupCountryByIP("1.0.0.1")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "DE")
+	if country, _ := memory.Cache.getCountry(netip.MustParseAddr("1.0.0.1")); country != "FR" {
+		t.Errorf("A closed locator should not follow changes, got %q", country)
+	}
+
+	// Other locators still do
+	if country, err := locator.lookupCountryByIP("1.0.0.1"); err != nil || country != "DE" {
+		t.Errorf("Incorrect country for 1.0.0.1. Expected: DE, Got: %q (err: %v)", country, err)
+	}
+	locator.close()
+	if n := listeners(); n != 0 {
+		t.Errorf("Expected no listener left, got %d", n)
+	}
+}
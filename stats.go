@@ -0,0 +1,240 @@
+package ipcountrylocator
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// LookupSource indique le chemin ayant produit (ou tenté de produire) le résultat d'un lookup.
+type LookupSource int
+
+const (
+	// SourceCache: résultat servi par le cache.
+	SourceCache LookupSource = iota
+	// SourceMemory: résultat servi par l'instantané mémoire (WithMemoryTable / NewMemoryLocator).
+	SourceMemory
+	// SourceNumeric: résultat servi par un bucket numérique BoltDB.
+	SourceNumeric
+	// SourceText: résultat servi par le scan du bucket texte (fallback).
+	SourceText
+	// SourceNegativeCache: absence de plage servie par le cache négatif (WithNegativeCache).
+	SourceNegativeCache
+
+	sourceCount = iota
+)
+
+// String retourne le nom du chemin.
+func (s LookupSource) String() string {
+	switch s {
+	case SourceCache:
+		return "cache"
+	case SourceMemory:
+		return "memory"
+	case SourceNumeric:
+		return "numeric"
+	case SourceText:
+		return "text"
+	case SourceNegativeCache:
+		return "negative_cache"
+	default:
+		return "unknown"
+	}
+}
+
+// latencyBounds sont les bornes supérieures (inclusives) des classes des histogrammes de latence.
+var latencyBounds = [...]time.Duration{
+	250 * time.Nanosecond,
+	500 * time.Nanosecond,
+	time.Microsecond,
+	2500 * time.Nanosecond,
+	5 * time.Microsecond,
+	10 * time.Microsecond,
+	25 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// LatencyHistogram est un instantané d'histogramme de latence.
+// Counts[i] compte les lookups de durée <= Bounds[i] (et > Bounds[i-1]);
+// le dernier élément de Counts (len(Bounds)) compte les lookups au-delà de la dernière borne.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Mean retourne la latence moyenne (0 si aucun lookup).
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// LocatorStats est un instantané des compteurs d'un IPLocator.
+// Les compteurs Cache* (NegativeCache*) restent nuls si le cache (négatif) est désactivé.
+// NumericLookups compte les recherches dans un bucket numérique, TextLookups les scans du bucket
+// texte après échec de la recherche numérique: un lookup peut donc incrémenter les deux.
+type LocatorStats struct {
+	CacheHits        uint64
+	CacheMisses      uint64
+	CacheEvictions   uint64 // Entrées évincées par la politique LRU
+	CacheExpirations uint64 // Entrées expirées (WithCacheTTL)
+	CacheSize        int
+	CacheCapacity    int
+
+	NegativeCacheHits        uint64 // Lookups non trouvés servis par le cache négatif (inclus dans NotFound)
+	NegativeCacheEvictions   uint64
+	NegativeCacheExpirations uint64
+	NegativeCacheSize        int
+	NegativeCacheCapacity    int
+
+	MemoryLookups  uint64
+	NumericLookups uint64
+	TextLookups    uint64
+	NotFound       uint64
+	InvalidIP      uint64
+
+	// Latency contient un histogramme par chemin de résolution (indexé par LookupSource).
+	// Un lookup sans résultat est compté dans le dernier chemin essayé.
+	Latency [sourceCount]LatencyHistogram
+}
+
+// latencyHistogram est un histogramme concurrent (compteurs atomiques, sans verrou).
+type latencyHistogram struct {
+	counts [len(latencyBounds) + 1]atomic.Uint64
+	sum    atomic.Int64
+}
+
+// observe enregistre une durée.
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// snapshot copie l'histogramme.
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	snap := LatencyHistogram{
+		Bounds: latencyBounds[:],
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.counts {
+		snap.Counts[i] = h.counts[i].Load()
+		snap.Count += snap.Counts[i]
+	}
+	return snap
+}
+
+// locatorMetrics regroupe les compteurs d'un IPLocator hors cache.
+type locatorMetrics struct {
+	memory   atomic.Uint64
+	numeric  atomic.Uint64
+	text     atomic.Uint64
+	notFound atomic.Uint64
+	invalid  atomic.Uint64
+	latency  [sourceCount]latencyHistogram
+}
+
+// stats construit un instantané des compteurs du localisateur et de son cache.
+func (l *IPLocator) stats() LocatorStats {
+	cache := l.Cache.stats()
+	negative := l.NegativeCache.stats()
+	st := LocatorStats{
+		CacheHits:        cache.hits,
+		CacheMisses:      cache.misses,
+		CacheEvictions:   cache.evictions,
+		CacheExpirations: cache.expirations,
+		CacheSize:        cache.size,
+		CacheCapacity:    max(l.Cache.maxSize, 0),
+
+		NegativeCacheHits:        negative.hits,
+		NegativeCacheEvictions:   negative.evictions,
+		NegativeCacheExpirations: negative.expirations,
+		NegativeCacheSize:        negative.size,
+		NegativeCacheCapacity:    max(l.NegativeCache.maxSize, 0),
+
+		MemoryLookups:  l.metrics.memory.Load(),
+		NumericLookups: l.metrics.numeric.Load(),
+		TextLookups:    l.metrics.text.Load(),
+		NotFound:       l.metrics.notFound.Load(),
+		InvalidIP:      l.metrics.invalid.Load(),
+	}
+	for i := range l.metrics.latency {
+		st.Latency[i] = l.metrics.latency[i].snapshot()
+	}
+	return st
+}
+
+// writePrometheus écrit les compteurs au format texte d'exposition Prometheus (version 0.0.4).
+func (l *IPLocator) writePrometheus(w io.Writer) error {
+	st := l.stats()
+	ew := &errWriter{w: w}
+
+	counter := func(name, help string, value uint64) {
+		ew.printf("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	gauge := func(name, help string, value int) {
+		ew.printf("# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+
+	counter("ipcountry_cache_hits_total", "Lookups served by the cache.", st.CacheHits)
+	counter("ipcountry_cache_misses_total", "Lookups not found in the cache.", st.CacheMisses)
+	counter("ipcountry_cache_evictions_total", "Cache entries evicted by the LRU policy.", st.CacheEvictions)
+	counter("ipcountry_cache_expirations_total", "Cache entries expired by the TTL.", st.CacheExpirations)
+	gauge("ipcountry_cache_entries", "Current number of cache entries.", st.CacheSize)
+	gauge("ipcountry_cache_capacity", "Maximum number of cache entries.", st.CacheCapacity)
+	counter("ipcountry_negative_cache_hits_total", "Not-found lookups served by the negative cache.", st.NegativeCacheHits)
+	counter("ipcountry_negative_cache_evictions_total", "Negative cache entries evicted by the LRU policy.", st.NegativeCacheEvictions)
+	counter("ipcountry_negative_cache_expirations_total", "Negative cache entries expired by the TTL.", st.NegativeCacheExpirations)
+	gauge("ipcountry_negative_cache_entries", "Current number of negative cache entries.", st.NegativeCacheSize)
+	gauge("ipcountry_negative_cache_capacity", "Maximum number of negative cache entries.", st.NegativeCacheCapacity)
+	counter("ipcountry_memory_lookups_total", "Lookups in the in-memory range table.", st.MemoryLookups)
+	counter("ipcountry_numeric_lookups_total", "Lookups in the numeric buckets.", st.NumericLookups)
+	counter("ipcountry_text_lookups_total", "Text bucket scans after a numeric miss.", st.TextLookups)
+	counter("ipcountry_not_found_total", "Lookups without a matching range.", st.NotFound)
+	counter("ipcountry_invalid_ip_total", "Lookups rejected as invalid IP addresses.", st.InvalidIP)
+
+	const histogram = "ipcountry_lookup_duration_seconds"
+	ew.printf("# HELP %s Lookup latency by resolution path.\n# TYPE %s histogram\n", histogram, histogram)
+	for source, h := range st.Latency {
+		var cumulative uint64
+		for i, bound := range h.Bounds {
+			cumulative += h.Counts[i]
+			ew.printf("%s_bucket{source=%q,le=\"%g\"} %d\n", histogram, LookupSource(source), bound.Seconds(), cumulative)
+		}
+		ew.printf("%s_bucket{source=%q,le=\"+Inf\"} %d\n", histogram, LookupSource(source), h.Count)
+		ew.printf("%s_sum{source=%q} %g\n", histogram, LookupSource(source), h.Sum.Seconds())
+		ew.printf("%s_count{source=%q} %d\n", histogram, LookupSource(source), h.Count)
+	}
+
+	return ew.err
+}
+
+// errWriter conserve la première erreur d'écriture et ignore les écritures suivantes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...any) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
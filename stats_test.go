@@ -0,0 +1,159 @@
+package ipcountrylocator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestLocatorStats(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start, end, _ := parseIPRange("1.0.0.0/24")
+	if _, err := manager.upsertIPRangeCountry("1.0.0.0/24", start, end, "FR"); err != nil {
+		t.Fatalf("Error adding IP range: %v", err)
+	}
+
+	// A range only present in the text bucket is resolved by the fallback scan
+	err := manager.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("ip_ranges")).Put([]byte("2.0.0.0/24"), []byte("DE"))
+	})
+	if err != nil {
+		t.Fatalf("Error adding text range: %v", err)
+	}
+
+	locator := newIPLocator(manager, 2, WithTextFallback())
+
+	lookups := []string{
+		"1.0.0.1", // numeric
+		"1.0.0.1", // cache hit
+		"2.0.0.1", // numeric miss, text hit
+		"9.9.9.9", // not found
+		"bogus",   // invalid: rejected before the cache
+		"1.0.0.2", // numeric, evicts the least recently used entry
+		"2.0.0.1", // cache hit
+		"1.0.0.1", // evicted: numeric again
+	}
+	for _, ip := range lookups {
+		locator.lookupCountryByIP(ip)
+	}
+
+	st := locator.stats()
+
+	expected := []struct {
+		name      string
+		got, want uint64
+	}{
+		{"CacheHits", st.CacheHits, 2},
+		{"CacheMisses", st.CacheMisses, 5},
+		{"CacheEvictions", st.CacheEvictions, 2},
+		{"NumericLookups", st.NumericLookups, 5},
+		{"TextLookups", st.TextLookups, 2},
+		{"NotFound", st.NotFound, 1},
+		{"InvalidIP", st.InvalidIP, 1},
+		{"MemoryLookups", st.MemoryLookups, 0},
+	}
+	for _, e := range expected {
+		if e.got != e.want {
+			t.Errorf("Incorrect %s. Expected: %d, Got: %d", e.name, e.want, e.got)
+		}
+	}
+
+	if st.CacheSize != 2 || st.CacheCapacity != 2 {
+		t.Errorf("Incorrect cache size: %d / %d", st.CacheSize, st.CacheCapacity)
+	}
+
+	// Every valid lookup is timed under its last resolution path
+	latencies := map[LookupSource]uint64{SourceCache: 2, SourceNumeric: 3, SourceText: 2}
+	for source, want := range latencies {
+		h := st.Latency[source]
+		if h.Count != want {
+			t.Errorf("Incorrect %s latency count. Expected: %d, Got: %d", source, want, h.Count)
+		}
+		if len(h.Counts) != len(h.Bounds)+1 {
+			t.Errorf("Incorrect %s histogram layout", source)
+		}
+		if h.Sum <= 0 || h.Mean() <= 0 {
+			t.Errorf("Incorrect %s latency sum: %v", source, h.Sum)
+		}
+	}
+}
+
+func TestLocatorStatsMemory(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start, end, _ := parseIPRange("1.0.0.0/24")
+	manager.upsertIPRangeCountry("1.0.0.0/24", start, end, "FR")
+
+	locator, err := newMemoryIPLocator(manager, 0)
+	if err != nil {
+		t.Fatalf("Failed to create memory locator: %v", err)
+	}
+
+	locator.lookupCountryByIP("1.0.0.1")
+	locator.lookupCountryByIP("9.9.9.9")
+
+	st := locator.stats()
+	if st.MemoryLookups != 2 || st.NotFound != 1 || st.NumericLookups != 0 {
+		t.Errorf("Incorrect memory counters: %+v", st)
+	}
+	if st.CacheHits != 0 || st.CacheMisses != 0 || st.CacheCapacity != 0 {
+		t.Errorf("A disabled cache should not count: %+v", st)
+	}
+	if st.Latency[SourceMemory].Count != 2 {
+		t.Errorf("Incorrect memory latency count: %d", st.Latency[SourceMemory].Count)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	h.observe(100 * time.Nanosecond)
+	h.observe(3 * time.Microsecond)
+	h.observe(time.Second)
+
+	snap := h.snapshot()
+	if snap.Count != 3 || snap.Sum != time.Second+3100*time.Nanosecond {
+		t.Errorf("Incorrect histogram totals: %d, %v", snap.Count, snap.Sum)
+	}
+	if snap.Counts[0] != 1 || snap.Counts[4] != 1 || snap.Counts[len(snap.Bounds)] != 1 {
+		t.Errorf("Incorrect histogram buckets: %v", snap.Counts)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start, end, _ := parseIPRange("1.0.0.0/24")
+	manager.upsertIPRangeCountry("1.0.0.0/24", start, end, "FR")
+
+	locator := newIPLocator(manager, 10)
+	locator.lookupCountryByIP("1.0.0.1")
+	locator.lookupCountryByIP("1.0.0.1")
+
+	var buf bytes.Buffer
+	if err := locator.writePrometheus(&buf); err != nil {
+		t.Fatalf("Error writing metrics: %v", err)
+	}
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE ipcountry_cache_hits_total counter",
+		"ipcountry_cache_hits_total 1",
+		"ipcountry_cache_misses_total 1",
+		"ipcountry_numeric_lookups_total 1",
+		"ipcountry_cache_entries 1",
+		"# TYPE ipcountry_lookup_duration_seconds histogram",
+		`ipcountry_lookup_duration_seconds_bucket{source="cache",le="+Inf"} 1`,
+		`ipcountry_lookup_duration_seconds_count{source="numeric"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Missing metric line %q", line)
+		}
+	}
+}
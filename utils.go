@@ -1,8 +1,10 @@
 package ipcountrylocator
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
 )
 
@@ -13,6 +15,13 @@ type IPRange struct {
 	Country string
 }
 
+// IPRange6 représente une plage inclusive d'adresses IPv6 (Start à End, 16 octets big-endian) associée à un code pays
+type IPRange6 struct {
+	Start   [16]byte
+	End     [16]byte
+	Country string
+}
+
 // ipv4ToUint32 convertit une IPv4 en entier 32 bits.
 func ipv4ToUint32(ip net.IP) uint32 {
 	ip = ip.To4()
@@ -22,6 +31,13 @@ func ipv4ToUint32(ip net.IP) uint32 {
 	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
 }
 
+// ipv6ToBytes convertit une IPv6 en tableau de 16 octets (forme big-endian).
+func ipv6ToBytes(ip net.IP) [16]byte {
+	var b [16]byte
+	copy(b[:], ip.To16())
+	return b
+}
+
 // encodeUint32BE écrit un uint32 en big-endian.
 func encodeUint32BE(b []byte, v uint32) {
 	b[0] = byte(v >> 24)
@@ -35,6 +51,163 @@ func decodeUint32BE(b []byte) uint32 {
 	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
 }
 
+// ipv4RangeKey construit la clé numérique start|end (8 octets big-endian).
+func ipv4RangeKey(start, end uint32) []byte {
+	key := make([]byte, 8)
+	encodeUint32BE(key[0:4], start)
+	encodeUint32BE(key[4:8], end)
+	return key
+}
+
+// ipv6RangeKey construit la clé numérique start|end (32 octets big-endian).
+func ipv6RangeKey(start, end [16]byte) []byte {
+	key := make([]byte, 32)
+	copy(key[0:16], start[:])
+	copy(key[16:32], end[:])
+	return key
+}
+
+// validCountryCode indique si code est un code ISO 3166-1 alpha-2 attribué ou exceptionnellement réservé
+// (casse libre, comme les noms de fichiers .zone; les upserts et réaffectations le stockent en majuscules).
+func validCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+
+	a, b := code[0]|0x20, code[1]|0x20
+	if a < 'a' || a > 'z' || b < 'a' || b > 'z' {
+		return false
+	}
+	return isoCodes[a-'a'][b-'a']
+}
+
+// parseRangeKey parse une plage IPv4 puis, à défaut, IPv6 et retourne sa clé numérique.
+func parseRangeKey(ipRange string) ([]byte, error) {
+	if start, end, err := parseIPRange(ipRange); err == nil {
+		return ipv4RangeKey(start, end), nil
+	}
+
+	start, end, err := parseIPv6Range(ipRange)
+	if err != nil {
+		return nil, err
+	}
+	return ipv6RangeKey(start, end), nil
+}
+
+// invertedRange indique si la clé start|end a un début supérieur à sa fin (plage jamais résolue).
+func invertedRange(key []byte) bool {
+	width := len(key) / 2
+	return bytes.Compare(key[:width], key[width:]) > 0
+}
+
+// formatRangeKey reconstruit une plage "start-end" lisible à partir d'une clé numérique.
+func formatRangeKey(key []byte) string {
+	width := len(key) / 2
+	return net.IP(key[:width]).String() + "-" + net.IP(key[width:]).String()
+}
+
+// canonicalRange retourne la forme texte canonique d'une clé numérique start|end: CIDR masqué si la plage
+// correspond exactement à un préfixe, "start-end" normalisé sinon (IPv6 au format RFC 5952).
+func canonicalRange(key []byte) string {
+	width := len(key) / 2
+	first, last := addrFromKeyPart(key[:width]), addrFromKeyPart(key[width:])
+	if prefixes := rangeToPrefixes(first, last); len(prefixes) == 1 {
+		return prefixes[0].String()
+	}
+	return first.String() + "-" + last.String()
+}
+
+// addrsRangeKey construit la clé numérique start|end de deux adresses de même famille.
+func addrsRangeKey(start, end netip.Addr) []byte {
+	return append(start.AsSlice(), end.AsSlice()...)
+}
+
+// rangeContains indique si la plage outer contient entièrement la plage inner (clés start|end de même largeur).
+func rangeContains(outer, inner []byte) bool {
+	width := len(outer) / 2
+	return bytes.Compare(outer[:width], inner[:width]) <= 0 && bytes.Compare(outer[width:], inner[width:]) >= 0
+}
+
+// subtractRange retourne les parties de la plage key situées hors de cut (clés start|end de même largeur):
+// aucune si cut contient key, key elle-même si elles sont disjointes, sinon une ou deux plages par ordre croissant.
+func subtractRange(key, cut []byte) [][]byte {
+	width := len(key) / 2
+	start, end := key[:width], key[width:]
+	cutStart, cutEnd := cut[:width], cut[width:]
+
+	if bytes.Compare(cutEnd, start) < 0 || bytes.Compare(cutStart, end) > 0 {
+		return [][]byte{key}
+	}
+
+	var parts [][]byte
+	if bytes.Compare(cutStart, start) > 0 {
+		parts = append(parts, addrsRangeKey(addrFromKeyPart(start), addrFromKeyPart(cutStart).Prev()))
+	}
+	if bytes.Compare(cutEnd, end) < 0 {
+		parts = append(parts, addrsRangeKey(addrFromKeyPart(cutEnd).Next(), addrFromKeyPart(end)))
+	}
+	return parts
+}
+
+// addrFromKeyPart convertit une borne de clé numérique (4 ou 16 octets) en netip.Addr.
+func addrFromKeyPart(b []byte) netip.Addr {
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// normalizePrefix masque un préfixe et ramène un préfixe IPv4-mapped (::ffff:a.b.c.d/96+) en IPv4.
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix
+}
+
+// prefixBounds retourne la première et la dernière adresse d'un préfixe masqué.
+func prefixBounds(prefix netip.Prefix) (netip.Addr, netip.Addr) {
+	first := prefix.Addr()
+	b := first.As16()
+
+	// Set the host bits (counted from the least significant bit of the 128-bit form)
+	for i := 0; i < first.BitLen()-prefix.Bits(); i++ {
+		b[15-i/8] |= 1 << (i % 8)
+	}
+
+	last := netip.AddrFrom16(b)
+	if first.Is4() {
+		last = last.Unmap()
+	}
+	return first, last
+}
+
+// rangeToPrefixes décompose la plage inclusive [first, last] (même famille) en la liste minimale
+// de préfixes CIDR, par ordre croissant.
+func rangeToPrefixes(first, last netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+
+	for first.IsValid() && first.Compare(last) <= 0 {
+		// Widen the prefix while it stays aligned on first and inside the range
+		bits := first.BitLen()
+		for bits > 0 {
+			wider := netip.PrefixFrom(first, bits-1).Masked()
+			_, end := prefixBounds(wider)
+			if wider.Addr() != first || end.Compare(last) > 0 {
+				break
+			}
+			bits--
+		}
+
+		prefix := netip.PrefixFrom(first, bits)
+		prefixes = append(prefixes, prefix)
+
+		_, end := prefixBounds(prefix)
+		first = end.Next()
+	}
+
+	return prefixes
+}
+
 // parseIPRange parse "start-end" ou CIDR et retourne (start,end).
 func parseIPRange(ipRange string) (uint32, uint32, error) {
 	// Check if it's a CIDR
@@ -44,12 +217,21 @@ func parseIPRange(ipRange string) (uint32, uint32, error) {
 			return 0, 0, err
 		}
 
+		if ipNet.IP.To4() == nil {
+			return 0, 0, fmt.Errorf("not an IPv4 range")
+		}
+
 		// Calculate the start address
 		start := ipv4ToUint32(ipNet.IP)
 
-		// Calculate the end address using the mask
-		mask := ipNet.Mask
-		maskSize, _ := mask.Size()
+		// Calculate the end address using the mask (IPv4-mapped masks are 128 bits wide)
+		maskSize, bits := ipNet.Mask.Size()
+		if bits == 128 {
+			maskSize -= 96
+		}
+		if maskSize < 0 {
+			return 0, 0, fmt.Errorf("invalid IPv4-mapped prefix length")
+		}
 		hostBits := 32 - maskSize
 		end := start | (1<<uint(hostBits) - 1)
 
@@ -75,6 +257,51 @@ func parseIPRange(ipRange string) (uint32, uint32, error) {
 	return start, end, nil
 }
 
+// parseIPv6Range parse "start-end" ou CIDR IPv6 et retourne (start,end) sur 16 octets.
+// Les adresses IPv4 (y compris IPv4-mapped) sont refusées: elles relèvent de parseIPRange.
+func parseIPv6Range(ipRange string) ([16]byte, [16]byte, error) {
+	var start, end [16]byte
+
+	// Check if it's a CIDR
+	if strings.Contains(ipRange, "/") {
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return start, end, err
+		}
+
+		if ipNet.IP.To4() != nil {
+			return start, end, fmt.Errorf("not an IPv6 range")
+		}
+
+		// The network address is the start, the end sets every host bit
+		start = ipv6ToBytes(ipNet.IP)
+		for i := range end {
+			end[i] = start[i] | ^ipNet.Mask[i]
+		}
+
+		return start, end, nil
+	}
+
+	// Otherwise, check if it's a range in the form "start-end"
+	parts := strings.Split(ipRange, "-")
+	if len(parts) != 2 {
+		return start, end, fmt.Errorf("invalid IP range format")
+	}
+
+	startIP := net.ParseIP(strings.TrimSpace(parts[0]))
+	endIP := net.ParseIP(strings.TrimSpace(parts[1]))
+
+	if startIP == nil || endIP == nil {
+		return start, end, fmt.Errorf("invalid IP address")
+	}
+
+	if startIP.To4() != nil || endIP.To4() != nil {
+		return start, end, fmt.Errorf("not an IPv6 range")
+	}
+
+	return ipv6ToBytes(startIP), ipv6ToBytes(endIP), nil
+}
+
 // ipv4InCIDR teste l'appartenance d'une IPv4 à un CIDR.
 func ipv4InCIDR(ip string, cidr string) bool {
 	_, ipNet, err := net.ParseCIDR(cidr)
@@ -119,7 +346,35 @@ func ipv4InRange(ip string, ipRange string) bool {
 	return ipInt >= startInt && ipInt <= endInt
 }
 
-// isPrivateOrLocalCIDR détecte si un CIDR est privé / loopback / link-local.
+// ipv6InRange teste une IPv6 contre "start-end" ou CIDR IPv6.
+func ipv6InRange(ip string, ipRange string) bool {
+	ipAddr := net.ParseIP(ip)
+	if ipAddr == nil || ipAddr.To4() != nil {
+		return false
+	}
+
+	start, end, err := parseIPv6Range(ipRange)
+	if err != nil {
+		return false
+	}
+
+	ipBytes := ipv6ToBytes(ipAddr)
+	return bytes.Compare(ipBytes[:], start[:]) >= 0 && bytes.Compare(ipBytes[:], end[:]) <= 0
+}
+
+// ipInRange teste une IP (v4 ou v6) contre une plage de la même famille.
+func ipInRange(ip string, ipRange string) bool {
+	ipAddr := net.ParseIP(ip)
+	if ipAddr == nil {
+		return false
+	}
+	if ipAddr.To4() != nil {
+		return ipv4InRange(ip, ipRange)
+	}
+	return ipv6InRange(ip, ipRange)
+}
+
+// isPrivateOrLocalCIDR détecte si un CIDR (IPv4 ou IPv6) est privé / loopback / link-local.
 func isPrivateOrLocalCIDR(ipRange string) bool {
 	// Extract the IP address from the range (before the "/")
 	parts := strings.Split(ipRange, "/")
@@ -2,6 +2,7 @@ package ipcountrylocator
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 )
 
@@ -64,10 +65,15 @@ func TestParseIPRange(t *testing.T) {
 		{"192.168.1.0/24", true, 3232235776, 3232236031}, // Corrected values to match current implementation
 		{"10.0.0.0/8", true, 167772160, 184549375},
 
+		// IPv4-mapped format
+		{"::ffff:8.8.8.0/120", true, 134744064, 134744319},
+
 		// Invalid format
 		{"invalid", false, 0, 0},
 		{"192.168.1.1", false, 0, 0},
 		{"192.168.1.1/invalid", false, 0, 0},
+		{"2001:db8::/32", false, 0, 0},
+		{"2001:db8::1-2001:db8::10", false, 0, 0},
 	}
 
 	for _, tc := range testCases {
@@ -87,6 +93,49 @@ func TestParseIPRange(t *testing.T) {
 	}
 }
 
+// TestParseIPv6Range couvre formats plage et CIDR IPv6, et le refus des adresses IPv4.
+func TestParseIPv6Range(t *testing.T) {
+	testCases := []struct {
+		ipRange     string
+		expectedOk  bool
+		expectedSt  string
+		expectedEnd string
+	}{
+		// Start-end format
+		{"2001:db8::1-2001:db8::10", true, "2001:db8::1", "2001:db8::10"},
+
+		// CIDR format
+		{"2001:db8::/32", true, "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"2a00:1450:4007::/48", true, "2a00:1450:4007::", "2a00:1450:4007:ffff:ffff:ffff:ffff:ffff"},
+		{"::/0", true, "::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+
+		// Invalid format
+		{"invalid", false, "", ""},
+		{"2001:db8::1", false, "", ""},
+		{"8.8.8.0/24", false, "", ""},
+		{"8.8.8.0-8.8.8.255", false, "", ""},
+		{"::ffff:8.8.8.0/120", false, "", ""},
+	}
+
+	for _, tc := range testCases {
+		start, end, err := parseIPv6Range(tc.ipRange)
+		if tc.expectedOk && err != nil {
+			t.Errorf("For %s: unexpected error: %v", tc.ipRange, err)
+		}
+		if !tc.expectedOk && err == nil {
+			t.Errorf("For %s: expected error but none was received", tc.ipRange)
+		}
+		if tc.expectedOk {
+			expectedSt := ipv6ToBytes(net.ParseIP(tc.expectedSt))
+			expectedEnd := ipv6ToBytes(net.ParseIP(tc.expectedEnd))
+			if start != expectedSt || end != expectedEnd {
+				t.Errorf("For %s: expected values (%s, %s), got (%s, %s)",
+					tc.ipRange, tc.expectedSt, tc.expectedEnd, net.IP(start[:]), net.IP(end[:]))
+			}
+		}
+	}
+}
+
 // TestIsIPInCIDR vérifie inclusion/exclusion et cas limites.
 func TestIsIPInCIDR(t *testing.T) {
 	testCases := []struct {
@@ -155,6 +204,41 @@ func TestIsIPInRange(t *testing.T) {
 	}
 }
 
+// TestIsIPInRangeIPv6 teste l'appartenance d'IPv6 (et d'IPv4) à des plages des deux familles.
+func TestIsIPInRangeIPv6(t *testing.T) {
+	testCases := []struct {
+		ip       string
+		ipRange  string
+		expected bool
+	}{
+		// IPv6 ranges
+		{"2001:db8::5", "2001:db8::1-2001:db8::10", true},
+		{"2001:db8::11", "2001:db8::1-2001:db8::10", false},
+		{"2001:db8:1::1", "2001:db8::/32", true},
+		{"2001:db9::1", "2001:db8::/32", false},
+
+		// Families never match each other
+		{"8.8.8.8", "2001:db8::/32", false},
+		{"2001:db8::1", "8.8.8.0/24", false},
+
+		// IPv4 and IPv4-mapped addresses use IPv4 ranges
+		{"8.8.8.8", "8.8.8.0-8.8.8.255", true},
+		{"::ffff:8.8.8.8", "8.8.8.0/24", true},
+
+		// Invalid
+		{"invalid", "2001:db8::/32", false},
+		{"2001:db8::1", "invalid", false},
+	}
+
+	for _, tc := range testCases {
+		result := ipInRange(tc.ip, tc.ipRange)
+		if result != tc.expected {
+			t.Errorf("For IP %s in range %s: expected value %v, got %v",
+				tc.ip, tc.ipRange, tc.expected, result)
+		}
+	}
+}
+
 // TestIsPrivateOrLocalRange vérifie la détection de réseaux privés / spéciaux.
 func TestIsPrivateOrLocalRange(t *testing.T) {
 	testCases := []struct {
@@ -172,6 +256,12 @@ func TestIsPrivateOrLocalRange(t *testing.T) {
 		// Link-local
 		{"169.254.0.0/16", true},
 
+		// IPv6 special ranges
+		{"fc00::/7", true},
+		{"::1/128", true},
+		{"fe80::/10", true},
+		{"2001:db8::/32", false},
+
 		// Public ranges
 		{"8.8.8.0/24", false},
 		{"1.1.1.0/24", false},
@@ -214,3 +304,57 @@ func TestIPRange(t *testing.T) {
 		t.Errorf("Incorrect country. Expected: FR, Got: %s", ipRange.Country)
 	}
 }
+
+func TestRangeToPrefixes(t *testing.T) {
+	testCases := []struct {
+		first, last string
+		expected    []string
+	}{
+		{"1.0.0.0", "1.0.0.255", []string{"1.0.0.0/24"}},
+		{"1.0.0.0", "1.0.0.0", []string{"1.0.0.0/32"}},
+		{"1.0.0.1", "1.0.0.6", []string{"1.0.0.1/32", "1.0.0.2/31", "1.0.0.4/31", "1.0.0.6/32"}},
+		{"10.0.0.0", "10.1.255.255", []string{"10.0.0.0/15"}},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"255.255.255.254", "255.255.255.255", []string{"255.255.255.254/31"}},
+		{"2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", []string{"2001:db8::/32"}},
+		{"2001:db8::1", "2001:db8::2", []string{"2001:db8::1/128", "2001:db8::2/128"}},
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}},
+		{"1.0.0.2", "1.0.0.1", nil},
+	}
+
+	for _, tc := range testCases {
+		prefixes := rangeToPrefixes(netip.MustParseAddr(tc.first), netip.MustParseAddr(tc.last))
+		if len(prefixes) != len(tc.expected) {
+			t.Errorf("%s-%s: expected %v, got %v", tc.first, tc.last, tc.expected, prefixes)
+			continue
+		}
+		for i, p := range prefixes {
+			if p.String() != tc.expected[i] {
+				t.Errorf("%s-%s: expected %v, got %v", tc.first, tc.last, tc.expected, prefixes)
+				break
+			}
+		}
+	}
+}
+
+func TestNormalizePrefix(t *testing.T) {
+	testCases := []struct {
+		prefix   string
+		expected string
+		first    string
+		last     string
+	}{
+		{"1.2.3.4/24", "1.2.3.0/24", "1.2.3.0", "1.2.3.255"},
+		{"::ffff:1.2.3.0/120", "1.2.3.0/24", "1.2.3.0", "1.2.3.255"},
+		{"2001:db8::1/32", "2001:db8::/32", "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"8.8.8.8/32", "8.8.8.8/32", "8.8.8.8", "8.8.8.8"},
+	}
+
+	for _, tc := range testCases {
+		prefix := normalizePrefix(netip.MustParsePrefix(tc.prefix))
+		first, last := prefixBounds(prefix)
+		if prefix.String() != tc.expected || first.String() != tc.first || last.String() != tc.last {
+			t.Errorf("%s: expected %s (%s-%s), got %s (%s-%s)", tc.prefix, tc.expected, tc.first, tc.last, prefix, first, last)
+		}
+	}
+}
@@ -1,10 +1,21 @@
 package ipcountrylocator
 
+import (
+	"io"
+	"net/netip"
+)
+
 // OpenDatabase ouvre (ou crée) la base BoltDB et garantit les buckets si lecture/écriture.
 // readOnly = true désactive la création de buckets.
+// opts: WithLockTimeout, WithFileMode, WithNoSync, WithPageSize, WithInitialMmapSize,
+// WithConflictPolicy, WithPrivateRangePolicy, WithLogger (par défaut aucun diagnostic n'est affiché),
+// WithMigrationDryRun.
+// En lecture / écriture, les migrations de schéma en attente sont appliquées (voir Migration);
+// en lecture seule, une base non migrée est refusée avec ErrSchemaOutdated.
+// Une base créée par une version plus récente est refusée avec ErrSchemaTooNew.
 // Retourne un *DBManager prêt à l'emploi.
-func OpenDatabase(path string, readOnly bool) (*DBManager, error) {
-	return openDatabase(path, readOnly)
+func OpenDatabase(path string, readOnly bool, opts ...DBOption) (*DBManager, error) {
+	return openDatabase(path, readOnly, opts...)
 }
 
 // Close ferme proprement la base BoltDB.
@@ -12,48 +23,231 @@ func (m *DBManager) Close() error {
 	return m.closeDatabase()
 }
 
+// SchemaVersion retourne la version du schéma enregistrée dans la base (0 si non versionnée).
+func (m *DBManager) SchemaVersion() (int, error) {
+	return m.schemaVersion()
+}
+
+// Migration retourne le résumé des migrations appliquées (ou simulées) par OpenDatabase,
+// nil pour une base ouverte en lecture seule.
+func (m *DBManager) Migration() *MigrationReport {
+	return m.migration
+}
+
 // ImportDirectory importe tous les fichiers *.zone d'un répertoire (ignore zz.zone).
-// Retourne (processedLines, updatedEntries, error).
-func (m *DBManager) ImportDirectory(dir string) (int, int, error) {
+// Les chevauchements entre pays sont résolus selon m.ConflictPolicy.
+// Retourne un ImportReport (compteurs par fichier, lignes invalides, conflits, durée);
+// l'erreur ne concerne que le parcours du répertoire, les échecs par fichier sont dans le rapport.
+func (m *DBManager) ImportDirectory(dir string) (*ImportReport, error) {
 	return m.importZoneDirectory(dir)
 }
 
 // ImportFile importe un fichier .zone unique.
-// Retourne (processedLines, updatedEntries, error).
-func (m *DBManager) ImportFile(file string) (int, int, error) {
+// Retourne un ImportReport et l'erreur ayant éventuellement interrompu le fichier.
+func (m *DBManager) ImportFile(file string) (*ImportReport, error) {
 	return m.importZoneFile(file)
 }
 
+// SyncDirectory remplace intégralement les données par le contenu des fichiers *.zone d'un répertoire
+// (ignore zz.zone): import complet dans des buckets de staging puis bascule atomique.
+// Les plages absentes des fichiers sont supprimées (ImportReport.Removed); en cas d'erreur la base reste inchangée.
+func (m *DBManager) SyncDirectory(dir string) (*ImportReport, error) {
+	return m.syncZoneDirectory(dir)
+}
+
 // UpsertRange insère ou remplace une plage IP (format "start-end" ou CIDR) pour un pays.
-// start/end doivent être fournis (utiliser ParseRange pour les dériver).
-// Retourne (true si succès, error).
+// start/end doivent être ceux de rangeStr (utiliser ParseRange pour les dériver, ou UpsertRangeString).
+// Retourne (true si succès, error); ErrReadOnly si la base est ouverte en lecture seule, ErrInvalidRange si
+// start > end ou si les bornes diffèrent de rangeStr, ErrInvalidCountry si country n'est pas un code ISO 3166-1.
 func (m *DBManager) UpsertRange(rangeStr string, start, end uint32, country string) (bool, error) {
 	return m.upsertIPRangeCountry(rangeStr, start, end, country)
 }
 
-// VerifyNumericIndex vérifie l'ordre des clés du bucket numérique.
+// UpsertRangeV6 insère ou remplace une plage IPv6 (format "start-end" ou CIDR) pour un pays.
+// start/end doivent être ceux de rangeStr (utiliser ParseRangeV6 pour les dériver).
+// Retourne (true si succès, error); mêmes erreurs que UpsertRange.
+func (m *DBManager) UpsertRangeV6(rangeStr string, start, end [16]byte, country string) (bool, error) {
+	return m.upsertIPv6RangeCountry(rangeStr, start, end, country)
+}
+
+// UpsertRangeString insère ou remplace une plage IPv4 ou IPv6 (format "start-end" ou CIDR) pour un pays,
+// bornes dérivées de rangeStr. Retourne (true si succès, error); *ParseError si la plage est invalide,
+// sinon mêmes erreurs que UpsertRange.
+func (m *DBManager) UpsertRangeString(rangeStr, country string) (bool, error) {
+	return m.upsertRangeString(rangeStr, country)
+}
+
+// UpsertPrefix insère ou remplace un préfixe IPv4 ou IPv6 pour un pays (stocké sous forme CIDR masquée).
+// Retourne (true si succès, error); ErrReadOnly si la base est ouverte en lecture seule.
+func (m *DBManager) UpsertPrefix(prefix netip.Prefix, country string) (bool, error) {
+	return m.upsertPrefixCountry(prefix, country)
+}
+
+// DeleteRange supprime une plage ("start-end" ou CIDR, IPv4 ou IPv6) de tous les buckets et index
+// (texte, numériques, provenance, /16, par pays) dans une seule transaction, y compris les autres formes texte
+// de mêmes bornes. Retourne le nombre de plages texte supprimées (0 si absente); *ParseError si la plage
+// est invalide, ErrReadOnly en lecture seule.
+func (m *DBManager) DeleteRange(rangeStr string) (int, error) {
+	return m.deleteIPRange(rangeStr)
+}
+
+// DeleteCountry supprime toutes les plages d'un pays de tous les buckets et index dans une seule transaction.
+// Retourne le nombre de plages texte supprimées; ErrReadOnly en lecture seule.
+func (m *DBManager) DeleteCountry(country string) (int, error) {
+	return m.deleteCountry(country)
+}
+
+// ReassignCountry associe au pays to toutes les plages du pays from, dans une seule transaction
+// (provenance UpsertSource). Retourne le nombre de plages texte réaffectées; ErrReadOnly en lecture seule.
+func (m *DBManager) ReassignCountry(from, to string) (int, error) {
+	return m.reassignCountry(from, to)
+}
+
+// Tx applique un lot de modifications (Writer: Upsert, UpsertPrefix, DeleteRange, DeleteCountry,
+// ReassignCountry) dans une seule transaction. Avant le commit, les codes pays et l'absence de chevauchement
+// entre une plage écrite et une plage d'un autre pays sont vérifiés (ErrInvalidCountry, ErrOverlap, *ParseError);
+// une erreur de fn ou de validation annule tout. ErrReadOnly en lecture seule. Le Writer n'est valide que pendant fn.
+func (m *DBManager) Tx(fn func(w *Writer) error) error {
+	return m.tx(fn)
+}
+
+// RangeProvenance retourne l'origine d'une plage stockée ("start-end" ou CIDR, IPv4 ou IPv6, mêmes bornes
+// que la plage importée): fichier ou UpsertSource, ligne, identifiant d'import (ImportReport.RunID) et dates.
+// Erreurs: *ParseError si la plage est invalide, ErrNotFound si aucune provenance n'est enregistrée.
+func (m *DBManager) RangeProvenance(rangeStr string) (Provenance, error) {
+	return m.rangeProvenance(rangeStr)
+}
+
+// VerifyNumericIndex vérifie l'ordre des clés des buckets numériques (IPv4 et IPv6).
 // Retourne (count, error).
 func (m *DBManager) VerifyNumericIndex() (int, error) {
 	return m.verifyRangeIndexes()
 }
 
-// NewLocator crée un localisateur IP avec cache mémoire (taille en entrées).
-func NewLocator(mgr *DBManager, cacheSize int) *IPLocator {
-	return newIPLocator(mgr, cacheSize)
+// CheckIndex compare le bucket texte (référence) aux index numériques et à l'index des /16 et retourne
+// les écarts (IndexReport), dont les plages numériques qui se recouvrent et que la recherche ne peut plus
+// atteindre. Avec repair = true, les index sont reconstruits à partir du bucket texte et les recouvrements
+// découpés dans une seule transaction (ErrReadOnly en lecture seule). Remplace le scan texte à chaque adresse inconnue
+// (voir WithTextFallback): à lancer après une écriture externe ou en cas de doute sur la base.
+func (m *DBManager) CheckIndex(repair bool) (*IndexReport, error) {
+	return m.checkIndex(repair)
 }
 
-// Lookup résout le code pays (ISO 2 lettres attendu dans les données) pour une IPv4.
-// Recherche: cache -> index numérique -> fallback scan texte.
+// NewLocator crée un localisateur IP avec cache mémoire (taille en entrées, <= 0 désactive le cache).
+// opts: WithoutCache, WithCacheTTL, WithNegativeCache, WithMemoryTable, WithTextFallback.
+func NewLocator(mgr *DBManager, cacheSize int, opts ...LocatorOption) *IPLocator {
+	return newIPLocator(mgr, cacheSize, opts...)
+}
+
+// NewMemoryLocator crée un localisateur IP en mode mémoire: les buckets numériques sont chargés dans
+// une table triée immuable, les recherches se font par dichotomie sans transaction BoltDB.
+// L'instantané est remplacé atomiquement après chaque ImportDirectory / ImportFile / UpsertRange.
+func NewMemoryLocator(mgr *DBManager, cacheSize int, opts ...LocatorOption) (*IPLocator, error) {
+	return newMemoryIPLocator(mgr, cacheSize, opts...)
+}
+
+// Reload recharge l'instantané mémoire (utile si la base est modifiée par un autre processus).
+// Sans effet sur un localisateur créé par NewLocator.
+func (l *IPLocator) Reload() error {
+	return l.reloadTableIfEnabled()
+}
+
+// Close détache le localisateur du DBManager, qui le référence sinon (avec ses caches et son instantané
+// mémoire) tant qu'il est ouvert. Le localisateur ne suit plus les modifications des données et ne doit
+// plus être utilisé; appels répétés sans effet.
+func (l *IPLocator) Close() {
+	l.close()
+}
+
+// Lookup résout le code pays (ISO 2 lettres attendu dans les données) pour une IPv4 ou IPv6.
+// Les adresses IPv4-mapped (::ffff:a.b.c.d) sont traitées comme des IPv4.
+// Recherche: cache -> index numérique (-> scan texte avec WithTextFallback).
+// Erreurs: ErrInvalidIP, ErrNotFound, ErrBucketMissing (errors.Is).
 func (l *IPLocator) Lookup(ip string) (string, error) {
 	return l.lookupCountryByIP(ip)
 }
 
-// Ranges retourne toutes les plages (forme texte originale) associées à un pays.
+// LookupAddr résout le code pays d'une adresse déjà parsée (ex: netip.ParseAddrPort(r.RemoteAddr)),
+// sans conversion en chaîne. Même chemin et mêmes erreurs que Lookup.
+func (l *IPLocator) LookupAddr(addr netip.Addr) (string, error) {
+	return l.lookupCountryByAddr(addr)
+}
+
+// LookupDetailed résout ip comme Lookup et retourne en plus la plage retenue (Start / End, Prefixes),
+// sa provenance et le chemin ayant produit la réponse (cache, mémoire, numérique, texte).
+// Destiné au support: expliquer pourquoi une adresse est attribuée à un pays.
+func (l *IPLocator) LookupDetailed(ip string) (Result, error) {
+	return l.lookupDetailed(ip)
+}
+
+// LookupBatch résout un lot d'adresses (IPv4 / IPv6) en une seule transaction de lecture:
+// les adresses sont triées puis résolues par un balayage en avant des buckets numériques.
+// Les résultats sont dans l'ordre de ips, avec une erreur par élément (ErrInvalidIP, ErrNotFound);
+// l'erreur retournée concerne le lot entier (ex: ErrBucketMissing). Le cache n'est ni lu ni alimenté.
+func (l *IPLocator) LookupBatch(ips []string) ([]BatchResult, error) {
+	return l.lookupBatch(ips)
+}
+
+// LookupAddrs est l'équivalent de LookupBatch pour des netip.Addr déjà parsées
+// (une adresse invalide, ex: netip.Addr{}, donne ErrInvalidIP).
+func (l *IPLocator) LookupAddrs(addrs []netip.Addr) ([]BatchResult, error) {
+	return l.lookupAddrs(addrs)
+}
+
+// Stats retourne un instantané des compteurs du localisateur: hits / misses / évictions du cache,
+// lookups par chemin (mémoire, numérique, texte), non trouvés et histogrammes de latence.
+func (l *IPLocator) Stats() LocatorStats {
+	return l.stats()
+}
+
+// WritePrometheus écrit les compteurs de Stats au format texte d'exposition Prometheus
+// (ex: depuis un handler HTTP /metrics).
+func (l *IPLocator) WritePrometheus(w io.Writer) error {
+	return l.writePrometheus(w)
+}
+
+// Ranges retourne toutes les plages (forme canonique: CIDR masqué ou "start-end") associées à un pays,
+// dans l'ordre des clés texte.
+// Le coût est proportionnel au nombre de plages du pays (index par pays); pour les pays volumineux,
+// voir RangesPage et EachRange.
 func (l *IPLocator) Ranges(country string) ([]string, error) {
 	return l.listIPRangesByCountry(country)
 }
 
-// ParseRange parse une plage "start-end" OU un CIDR et retourne (startUint32, endUint32, error).
+// RangesPage retourne au plus limit plages d'un pays, après la plage after ("" pour la première page),
+// et le curseur de la page suivante ("" en fin de liste). Chaque page est lue dans sa propre transaction.
+func (l *IPLocator) RangesPage(country, after string, limit int) ([]string, string, error) {
+	return l.listIPRangesPage(country, after, limit)
+}
+
+// EachRange appelle fn pour chaque plage d'un pays sans construire de liste (une seule transaction
+// de lecture, à ne pas garder ouverte longtemps); une erreur de fn arrête le parcours et est retournée.
+func (l *IPLocator) EachRange(country string, fn func(rangeStr string) error) error {
+	return l.eachIPRange(country, fn)
+}
+
+// RangesFor retourne les plages d'un pays sous forme de préfixes CIDR (IPv4 puis IPv6, ordre croissant),
+// à partir de l'index par pays; une plage "start-end" non alignée donne plusieurs préfixes.
+func (l *IPLocator) RangesFor(country string) ([]netip.Prefix, error) {
+	return l.listPrefixesByCountry(country)
+}
+
+// ParseRange parse une plage IPv4 "start-end" OU un CIDR et retourne (startUint32, endUint32, error).
+// Les erreurs sont de type *ParseError.
 func ParseRange(rangeStr string) (uint32, uint32, error) {
-	return parseIPRange(rangeStr)
+	start, end, err := parseIPRange(rangeStr)
+	if err != nil {
+		return 0, 0, &ParseError{Text: rangeStr, Err: err}
+	}
+	return start, end, nil
+}
+
+// ParseRangeV6 parse une plage IPv6 "start-end" OU un CIDR et retourne (start, end, error) sur 16 octets.
+// Les erreurs sont de type *ParseError.
+func ParseRangeV6(rangeStr string) ([16]byte, [16]byte, error) {
+	start, end, err := parseIPv6Range(rangeStr)
+	if err != nil {
+		return [16]byte{}, [16]byte{}, &ParseError{Text: rangeStr, Err: err}
+	}
+	return start, end, nil
 }
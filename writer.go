@@ -0,0 +1,219 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// errWriterClosed est retournée par un Writer utilisé après la fin de sa transaction.
+var errWriterClosed = errors.New("writer used outside its transaction")
+
+// Writer applique des modifications aux buckets live dans la transaction ouverte par DBManager.Tx.
+// Rien n'est visible avant le commit; toute erreur retournée par une méthode (plage invalide, code pays
+// mal formé) est conservée et fait échouer la transaction, même si fn l'ignore.
+type Writer struct {
+	r       *liveRanges
+	written [][]byte
+	tracked map[string]bool
+	changed int
+	errs    []error
+}
+
+// Upsert associe une plage ("start-end" ou CIDR, IPv4 ou IPv6) à un pays.
+func (w *Writer) Upsert(rangeStr, country string) error {
+	if w.r == nil {
+		return errWriterClosed
+	}
+
+	key, err := parseRangeKey(rangeStr)
+	if err != nil {
+		return w.fail(&ParseError{Text: rangeStr, Err: err})
+	}
+	return w.upsert(rangeStr, key, country)
+}
+
+// UpsertPrefix associe un préfixe IPv4 ou IPv6 à un pays (stocké sous forme CIDR masquée, comme DBManager.UpsertPrefix).
+func (w *Writer) UpsertPrefix(prefix netip.Prefix, country string) error {
+	if w.r == nil {
+		return errWriterClosed
+	}
+	if !prefix.IsValid() {
+		return w.fail(&ParseError{Text: prefix.String(), Err: fmt.Errorf("invalid prefix")})
+	}
+
+	prefix = normalizePrefix(prefix)
+	first, last := prefixBounds(prefix)
+	if prefix.Addr().Is4() {
+		start, end := first.As4(), last.As4()
+		return w.upsert(prefix.String(), ipv4RangeKey(decodeUint32BE(start[:]), decodeUint32BE(end[:])), country)
+	}
+	return w.upsert(prefix.String(), ipv6RangeKey(first.As16(), last.As16()), country)
+}
+
+// DeleteRange supprime une plage et ses autres formes texte (voir DBManager.DeleteRange).
+// Retourne le nombre de plages texte supprimées.
+func (w *Writer) DeleteRange(rangeStr string) (int, error) {
+	if w.r == nil {
+		return 0, errWriterClosed
+	}
+
+	key, err := parseRangeKey(rangeStr)
+	if err != nil {
+		return 0, w.fail(&ParseError{Text: rangeStr, Err: err})
+	}
+
+	count, err := w.r.deleteRange(canonicalRange(key), key)
+	if err != nil {
+		return 0, w.fail(err)
+	}
+	w.changed += count
+	return count, nil
+}
+
+// DeleteCountry supprime toutes les plages d'un pays, code hors norme compris.
+// Retourne le nombre de plages texte supprimées.
+func (w *Writer) DeleteCountry(country string) (int, error) {
+	if w.r == nil {
+		return 0, errWriterClosed
+	}
+	if country == "" {
+		return 0, w.fail(fmt.Errorf("%w: empty code", ErrInvalidCountry))
+	}
+
+	count, err := w.r.deleteCountry(country)
+	if err != nil {
+		return 0, w.fail(err)
+	}
+	w.changed += count
+	return count, nil
+}
+
+// ReassignCountry associe à to (code ISO 3166-1 alpha-2) toutes les plages de from, qui peut être un code
+// hors norme à corriger. Retourne le nombre de plages texte réaffectées.
+func (w *Writer) ReassignCountry(from, to string) (int, error) {
+	if w.r == nil {
+		return 0, errWriterClosed
+	}
+	if from == "" {
+		return 0, w.fail(fmt.Errorf("%w: empty code", ErrInvalidCountry))
+	}
+	if !validCountryCode(to) {
+		return 0, w.fail(fmt.Errorf("%w: %q", ErrInvalidCountry, to))
+	}
+	to = strings.ToUpper(to)
+	if from == to {
+		return 0, nil
+	}
+
+	count, err := w.r.reassignCountry(from, to)
+	if err != nil {
+		return 0, w.fail(err)
+	}
+	w.changed += count
+	return count, nil
+}
+
+// upsert vérifie la plage (voir validateUpsert), l'écrit et la retient pour le contrôle des chevauchements
+// au commit. Une plage déjà couverte par une plage du même pays n'est pas écrite (voir liveRanges.upsert).
+func (w *Writer) upsert(rangeText string, key []byte, country string) error {
+	if w.r == nil {
+		return errWriterClosed
+	}
+	if err := validateUpsert(rangeText, key, country); err != nil {
+		return w.fail(err)
+	}
+	country = strings.ToUpper(country)
+
+	// Ranges of other countries are checked by validate, once the whole transaction is written
+	written, err := w.r.upsert(canonicalRange(key), key, country, false)
+	if err != nil {
+		return w.fail(err)
+	}
+	if !written {
+		return nil
+	}
+
+	w.track(key)
+	w.changed++
+	return nil
+}
+
+// track retient une plage écrite par la transaction, upsert ou partie d'une plage réduite par un upsert
+// du même pays, pour le contrôle des chevauchements au commit.
+func (w *Writer) track(key []byte) {
+	if !w.tracked[string(key)] {
+		w.tracked[string(key)] = true
+		w.written = append(w.written, key)
+	}
+}
+
+// fail conserve err pour faire échouer la transaction et la retourne.
+func (w *Writer) fail(err error) error {
+	w.errs = append(w.errs, err)
+	return err
+}
+
+// validate retourne les erreurs conservées et les chevauchements entre une plage écrite
+// et une plage d'un autre pays (état final de la transaction), nil sinon.
+func (w *Writer) validate() error {
+	errs := w.errs
+	for _, key := range w.written {
+		numeric := w.r.numericFor(key)
+		country := numeric.Get(key)
+		if country == nil {
+			// Deleted later in the same transaction
+			continue
+		}
+		for _, o := range findOverlaps(numeric, key, string(country)) {
+			errs = append(errs, fmt.Errorf("%w: %s (%s) overlaps %s (%s)",
+				ErrOverlap, canonicalRange(key), country, formatRangeKey(o.key), o.country))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tx exécute fn dans une seule transaction d'écriture: les modifications du Writer sont validées
+// (codes pays, chevauchements entre pays) puis enregistrées atomiquement, ou toutes annulées si fn
+// retourne une erreur ou si la validation échoue. Les abonnés sont notifiés après un commit modifiant des plages.
+func (m *DBManager) tx(fn func(w *Writer) error) error {
+	if err := m.checkWritable(); err != nil {
+		return fmt.Errorf("transaction rejected: %w", err)
+	}
+
+	var w *Writer
+	var runID string
+	changed := false
+	err := m.DB.Update(func(tx *bbolt.Tx) error {
+		r, err := openLiveRanges(tx)
+		if err != nil {
+			return err
+		}
+
+		w = &Writer{r: r, tracked: make(map[string]bool)}
+		r.onTrim = w.track
+		runID = r.runID
+		defer func() { w.r = nil }()
+
+		if err := fn(w); err != nil {
+			return err
+		}
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("transaction rejected: %w", err)
+		}
+		changed = r.changed
+		return r.finish()
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Info("transaction committed", "path", m.DBPath, "run_id", runID, "changes", w.changed)
+	if changed {
+		m.notifyChange()
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package ipcountrylocator
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "FR")
+	manager.upsertPrefixCountry(netip.MustParsePrefix("2.0.0.0/24"), "DE")
+
+	locator, err := newMemoryIPLocator(manager, 100)
+	if err != nil {
+		t.Fatalf("Error creating memory locator: %v", err)
+	}
+	locator.lookupCountryByIP("2.0.0.1")
+
+	err = manager.tx(func(w *Writer) error {
+		if err := w.Upsert("3.0.0.0-3.0.0.255", "US"); err != nil {
+			return err
+		}
+		if err := w.UpsertPrefix(netip.MustParsePrefix("2001:db8::/32"), "US"); err != nil {
+			return err
+		}
+		if _, err := w.DeleteRange("1.0.0.0/24"); err != nil {
+			return err
+		}
+		// Replaces the deleted range: no overlap in the final state
+		if err := w.Upsert("1.0.0.0/25", "BE"); err != nil {
+			return err
+		}
+		// Lower-case codes are stored upper-case: merged with the BE range, no overlap
+		if err := w.Upsert("1.0.0.0/26", "be"); err != nil {
+			return err
+		}
+		_, err := w.ReassignCountry("DE", "at")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	for ip, expected := range map[string]string{"3.0.0.1": "US", "2001:db8::1": "US", "1.0.0.1": "BE", "1.0.0.200": "", "2.0.0.1": "AT"} {
+		country, err := locator.lookupCountryByIP(ip)
+		if country != expected || (expected == "") != errors.Is(err, ErrNotFound) {
+			t.Errorf("Incorrect result for %s. Expected: %q, Got: %q (err: %v)", ip, expected, country, err)
+		}
+	}
+	if report, err := manager.checkIndex(false); err != nil || !report.Consistent() {
+		t.Errorf("Inconsistent index after transaction: %+v (err: %v)", report, err)
+	}
+
+	// One run ID for the whole transaction
+	p1, _ := manager.rangeProvenance("3.0.0.0/24")
+	p2, _ := manager.rangeProvenance("2.0.0.0/24")
+	if p1.RunID == "" || p1.RunID != p2.RunID || p1.Source != UpsertSource {
+		t.Errorf("Incorrect provenance: %+v / %+v", p1, p2)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	manager.upsertPrefixCountry(netip.MustParsePrefix("1.0.0.0/24"), "FR")
+
+	snapshot := func() map[string][]string {
+		locator := newIPLocator(manager, 0)
+		ranges := make(map[string][]string)
+		for _, country := range []string{"FR", "DE", "US"} {
+			ranges[country], _ = locator.listIPRangesByCountry(country)
+		}
+		return ranges
+	}
+	before := snapshot()
+
+	errStop := errors.New("stop")
+	cases := []struct {
+		name     string
+		fn       func(w *Writer) error
+		expected error
+	}{
+		{"callback error", func(w *Writer) error {
+			w.Upsert("3.0.0.0/24", "US")
+			return errStop
+		}, errStop},
+		{"overlap", func(w *Writer) error {
+			w.Upsert("3.0.0.0/24", "US")
+			return w.Upsert("1.0.0.128/25", "DE")
+		}, ErrOverlap},
+		{"inverted range", func(w *Writer) error {
+			w.Upsert("3.0.0.0/24", "US")
+			return w.Upsert("1.0.0.10-1.0.0.1", "FR")
+		}, ErrInvalidRange},
+		{"ignored invalid country", func(w *Writer) error {
+			w.Upsert("3.0.0.0/24", "USA")
+			return nil
+		}, ErrInvalidCountry},
+		{"invalid range", func(w *Writer) error {
+			_, err := w.DeleteRange("bogus")
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Errorf("Expected a *ParseError, got %v", err)
+			}
+			return nil
+		}, nil},
+	}
+	for _, c := range cases {
+		err := manager.tx(c.fn)
+		if err == nil || (c.expected != nil && !errors.Is(err, c.expected)) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, err)
+		}
+		if after := snapshot(); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: database modified by a rejected transaction: %v", c.name, after)
+		}
+	}
+
+	// The writer cannot be used once the transaction is over
+	var kept *Writer
+	manager.tx(func(w *Writer) error {
+		kept = w
+		return nil
+	})
+	if err := kept.Upsert("3.0.0.0/24", "US"); !errors.Is(err, errWriterClosed) {
+		t.Errorf("Expected errWriterClosed, got %v", err)
+	}
+}
+
+func TestTxTrimmedOverlap(t *testing.T) {
+	manager, _, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := manager.upsertRangeString("1.0.0.0/25", "DE"); err != nil {
+		t.Fatalf("Error adding IP range: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		ranges []string
+	}{
+		// The second range trims the first one to 1.0.0.0/24, which still overlaps the DE range
+		{"trimmed part overlaps", []string{"1.0.0.0-1.0.1.255", "1.0.1.0-1.0.2.255"}},
+		// The trimmed part has the bounds of the DE range
+		{"trimmed part on another range", []string{"1.0.0.0-1.0.1.255", "1.0.0.128-1.0.2.255"}},
+	}
+	for _, c := range cases {
+		err := manager.tx(func(w *Writer) error {
+			for _, r := range c.ranges {
+				w.Upsert(r, "FR")
+			}
+			return nil
+		})
+		if !errors.Is(err, ErrOverlap) {
+			t.Errorf("%s: expected ErrOverlap, got %v", c.name, err)
+		}
+	}
+
+	locator := newIPLocator(manager, 0)
+	if country, err := locator.lookupCountryByIP("1.0.0.5"); err != nil || country != "DE" {
+		t.Errorf("Incorrect country for 1.0.0.5. Expected: DE, Got: %q (err: %v)", country, err)
+	}
+	if report, err := manager.checkIndex(false); err != nil || !report.Consistent() {
+		t.Errorf("Inconsistent index after rejected transactions: %+v (err: %v)", report, err)
+	}
+}
+
+func TestTxReadOnly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	manager, err := openDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	manager.closeDatabase()
+
+	manager, err = openDatabase(dbPath, true)
+	if err != nil {
+		t.Fatalf("Error opening database read-only: %v", err)
+	}
+	defer manager.closeDatabase()
+
+	called := false
+	err = manager.tx(func(w *Writer) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrReadOnly) || called {
+		t.Errorf("Expected ErrReadOnly without calling fn, got %v (called: %v)", err, called)
+	}
+}